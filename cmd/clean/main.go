@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
+	"log/slog"
+	"os"
 	"time"
 
+	"github.com/swmh/gopetbin/internal/cleaner"
 	"github.com/swmh/gopetbin/internal/config"
 	"github.com/swmh/gopetbin/internal/db"
+	"github.com/swmh/gopetbin/internal/lock/mapmutex"
+	"github.com/swmh/gopetbin/internal/lock/redlock"
+	"github.com/swmh/gopetbin/internal/service"
 	"github.com/swmh/gopetbin/internal/storage"
 )
 
@@ -22,40 +27,66 @@ func main() {
 	}
 
 	c := storage.Config{
+		Driver:          cfg.Storage.Driver,
+		Path:            cfg.Storage.Path,
 		Addr:            cfg.Storage.Addr,
 		AccessKeyID:     cfg.Storage.User,
 		SecretAccessKey: cfg.Storage.Pass,
 		BucketName:      cfg.Storage.Name,
 	}
 
-	s, err := storage.New(c)
+	s, err := storage.Open(c)
 	if err != nil {
 		panic(err)
 	}
 
-	db, err := db.New(cfg.DB.Addr, cfg.DB.User, cfg.DB.Pass, cfg.DB.Name)
-	if err != nil {
-		panic(err)
+	dbConfig := db.Config{
+		Driver: cfg.DB.Driver,
+		Path:   cfg.DB.Path,
+		Addr:   cfg.DB.Addr,
+		User:   cfg.DB.User,
+		Pass:   cfg.DB.Pass,
+		Name:   cfg.DB.Name,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	expired, err := db.GetExpired(ctx)
+	db, err := db.Open(dbConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	for _, name := range expired {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	// The cleaner claims every blob in the repo, without Redis only the
+	// lock sparing replicas duplicate work is lost.
+	var locker service.Locker = mapmutex.New[string]()
 
-		err = s.DeleteFile(ctx, name)
+	if cfg.Locker.Addr != "" {
+		locker, err = redlock.New(cfg.Locker.Addr, cfg.Locker.User, cfg.Locker.Pass, cfg.Locker.DB)
 		if err != nil {
-			log.Printf("Cannot delete %s: %s\n", name, err)
-			continue
+			panic(err)
+		}
+	}
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	clnr := cleaner.New(cleaner.Config{
+		Repo:    db,
+		Storage: s,
+		Locker:  locker,
+		Logger:  logger,
+		Batch:   cfg.Clean.Batch,
+		Workers: cfg.Clean.Workers,
+
+		WriteTimeout: time.Duration(cfg.App.WriteTimeout) * time.Second,
+	})
+
+	res, err := clnr.Run(context.Background())
+	if err != nil {
+		if cleaner.IsLocked(err) {
+			logger.Info("Another cleaner is running")
+			return
 		}
 
-		log.Printf("Deleted: %s\n", name)
+		panic(err)
 	}
+
+	logger.Info("Clean finished", slog.Int("pastes", res.Pastes), slog.Int("expired", res.Expired), slog.Int("blobs", res.Blobs))
 }
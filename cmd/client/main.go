@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/swmh/gopetbin/pkg/client"
+)
+
+func main() {
+	var addr, get string
+	var opts client.Options
+	var encrypt bool
+
+	flag.StringVar(&addr, "addr", "http://localhost:8080", "Server address")
+	flag.StringVar(&get, "get", "", "Paste URL to download, decrypted if it has a key fragment")
+	flag.BoolVar(&encrypt, "encrypt", false, "Encrypt paste before upload")
+	flag.DurationVar(&opts.Expire, "expire", 0, "Expire time")
+	flag.IntVar(&opts.BurnAfter, "burn", 0, "Burn after reads")
+	flag.StringVar(&opts.Lang, "lang", "", "Language")
+	flag.StringVar(&opts.Password, "password", "", "Paste password")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c := client.New(addr, nil)
+
+	if get != "" {
+		data, err := c.Get(ctx, get, opts.Password)
+		if err != nil {
+			log.Fatalf("Cannot get paste: %s", err)
+		}
+
+		os.Stdout.Write(data)
+
+		return
+	}
+
+	in := os.Stdin
+
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Cannot open file: %s", err)
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	var u string
+
+	if encrypt {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			log.Fatalf("Cannot read content: %s", err)
+		}
+
+		u, err = c.CreateEncrypted(ctx, data, opts)
+		if err != nil {
+			log.Fatalf("Cannot create paste: %s", err)
+		}
+	} else {
+		var err error
+
+		u, err = c.Create(ctx, in, opts)
+		if err != nil {
+			log.Fatalf("Cannot create paste: %s", err)
+		}
+	}
+
+	fmt.Println(u)
+}
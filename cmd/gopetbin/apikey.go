@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/db"
+	"github.com/swmh/gopetbin/internal/service"
+)
+
+const apiKeyUsage = "usage: gopetbin apikey [-admin] <user>"
+
+// runAPIKey issues a new API key for the user and prints it, only its hash
+// is stored so it cannot be shown again. -admin makes the user an admin.
+func runAPIKey(repo db.Repository, args []string) error {
+	fs := flag.NewFlagSet("apikey", flag.ContinueOnError)
+	admin := fs.Bool("admin", false, "grant admin rights")
+
+	if err := fs.Parse(args); err != nil {
+		return errors.New(apiKeyUsage)
+	}
+
+	args = fs.Args()
+	if len(args) != 1 || args[0] == "" {
+		return errors.New(apiKeyUsage)
+	}
+
+	key, hash, err := service.NewAPIKey()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err = repo.CreateAPIKey(ctx, args[0], hash, *admin); err != nil {
+		return err
+	}
+
+	fmt.Println(key)
+
+	return nil
+}
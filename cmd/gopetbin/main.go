@@ -11,12 +11,20 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/swmh/gopetbin/internal/app"
 	"github.com/swmh/gopetbin/internal/cache"
+	"github.com/swmh/gopetbin/internal/cleaner"
 	"github.com/swmh/gopetbin/internal/config"
 	"github.com/swmh/gopetbin/internal/db"
+	"github.com/swmh/gopetbin/internal/lock/mapmutex"
 	"github.com/swmh/gopetbin/internal/lock/redlock"
+	l "github.com/swmh/gopetbin/internal/logger"
+	"github.com/swmh/gopetbin/internal/ratelimit"
+	"github.com/swmh/gopetbin/internal/server"
+	"github.com/swmh/gopetbin/internal/service"
 	"github.com/swmh/gopetbin/internal/storage"
+	"github.com/swmh/gopetbin/internal/tracing"
 )
 
 func main() {
@@ -42,36 +50,135 @@ func main() {
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 
-	repo, err := db.New(cfg.DB.Addr, cfg.DB.User, cfg.DB.Pass, cfg.DB.Name)
+	dbConfig := db.Config{
+		Driver: cfg.DB.Driver,
+		Path:   cfg.DB.Path,
+		Addr:   cfg.DB.Addr,
+		User:   cfg.DB.User,
+		Pass:   cfg.DB.Pass,
+		Name:   cfg.DB.Name,
+	}
+
+	repo, err := db.Open(dbConfig)
 	if err != nil {
 		panic(err)
 	}
 
+	switch flag.Arg(0) {
+	case "migrate":
+		if err = runMigrate(repo, flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %s", err)
+		}
+
+		return
+
+	case "apikey":
+		if err = runAPIKey(repo, flag.Args()[1:]); err != nil {
+			log.Fatalf("Cannot create api key: %s", err)
+		}
+
+		return
+	}
+
+	if cfg.DB.AutoMigrate {
+		if err = runMigrate(repo, []string{"up"}); err != nil {
+			log.Panicf("Migration failed: %s", err)
+		}
+	}
+
+	tracingConfig := tracing.Config{
+		Exporter: cfg.Tracing.Exporter,
+		Endpoint: cfg.Tracing.Endpoint,
+		Insecure: cfg.Tracing.Insecure,
+		Path:     cfg.Tracing.Path,
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingConfig)
+	if err != nil {
+		log.Panicf("Cannot setup tracing: %s", err)
+	}
+
 	storageConfig := storage.Config{
+		Driver:          cfg.Storage.Driver,
+		Path:            cfg.Storage.Path,
 		Addr:            cfg.Storage.Addr,
 		AccessKeyID:     cfg.Storage.User,
 		SecretAccessKey: cfg.Storage.Pass,
 		BucketName:      cfg.Storage.Name,
 	}
 
-	strg, err := storage.New(storageConfig)
+	strg, err := storage.Open(storageConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	cach, err := cache.New(cfg.Cache.Addr, cfg.Cache.User, cfg.Cache.Pass, cfg.Cache.DB)
-	if err != nil {
-		panic(err)
+	checks := map[string]server.Pinger{
+		"db":      repo,
+		"storage": strg,
 	}
 
-	fileCache, err := cache.NewFileCache(cfg.FileCache.Addr, cfg.FileCache.User, cfg.FileCache.Pass, cfg.FileCache.DB)
-	if err != nil {
-		panic(err)
+	// Without Redis the caches and locks are kept in process, which only
+	// suits a single node.
+	var cach service.Cache = cache.NewMemory(cfg.Cache.Size)
+
+	if cfg.Cache.Addr != "" {
+		c, err := cache.New(cfg.Cache.Addr, cfg.Cache.User, cfg.Cache.Pass, cfg.Cache.DB)
+		if err != nil {
+			panic(err)
+		}
+
+		cach, checks["cache"] = c, c
+	}
+
+	var fileCache service.FileCache = cache.NewFileCacheMemory(cfg.FileCache.MemorySize)
+
+	if cfg.FileCache.Addr != "" {
+		c, err := cache.NewFileCache(cfg.FileCache.Addr, cfg.FileCache.User, cfg.FileCache.Pass, cfg.FileCache.DB)
+		if err != nil {
+			panic(err)
+		}
+
+		fileCache, checks["file_cache"] = c, c
+	}
+
+	var locker service.Locker = mapmutex.New[string]()
+
+	if cfg.Locker.Addr != "" {
+		rl, err := redlock.New(cfg.Locker.Addr, cfg.Locker.User, cfg.Locker.Pass, cfg.Locker.DB)
+		if err != nil {
+			panic(err)
+		}
+
+		locker, checks["locker"] = rl, rl
 	}
 
-	locker, err := redlock.New(cfg.Locker.Addr, cfg.Locker.User, cfg.Locker.Pass, cfg.Locker.DB)
+	var limitClient *redis.Client
+
+	if cfg.RateLimit.Addr != "" {
+		limitClient, err = ratelimit.NewRedisClient(cfg.RateLimit.Addr, cfg.RateLimit.User, cfg.RateLimit.Pass, cfg.RateLimit.DB)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	trustedProxies, err := server.ParseTrustedProxies(cfg.RateLimit.TrustedProxies)
 	if err != nil {
-		panic(err)
+		log.Panicf("Cannot load config: %s", err)
+	}
+
+	var clnr *cleaner.Config
+
+	if cfg.Clean.Interval > 0 {
+		clnr = &cleaner.Config{
+			Repo:    repo,
+			Storage: strg,
+			Locker:  locker,
+			Logger:  logger,
+			Batch:   cfg.Clean.Batch,
+			Workers: cfg.Clean.Workers,
+
+			WriteTimeout: time.Duration(cfg.App.WriteTimeout) * time.Second,
+		}
 	}
 
 	c := app.Config{
@@ -81,11 +188,26 @@ func main() {
 		Cache:             cach,
 		FileCache:         fileCache,
 		Logger:            logger,
+		Checks:            checks,
+		CheckTimeout:      time.Duration(cfg.App.CheckTimeout) * time.Millisecond,
+		CreateLimiter:     newLimiter(limitClient, "ratelimit:create:", cfg.RateLimit.CreatePerMin, cfg.RateLimit.CreateBurst, logger),
+		ReadLimiter:       newLimiter(limitClient, "ratelimit:read:", cfg.RateLimit.ReadPerMin, cfg.RateLimit.ReadBurst, logger),
+		TrustedProxies:    trustedProxies,
+		InlineTypes:       server.ParseInlineTypes(cfg.App.InlineTypes),
+		MigrateBlobs:      cfg.App.MigrateBlobs,
+		Cleaner:           clnr,
+		CleanInterval:     time.Duration(cfg.Clean.Interval) * time.Second,
 		Addr:              cfg.App.Addr,
+		MetricsAddr:       cfg.Metrics.Addr,
 		PublicPath:        cfg.App.PublicPath,
-		MaxFileMemory:     cfg.App.MaxFileMemory,
 		IDLength:          cfg.App.IDLength,
 		MaxSize:           cfg.App.MaxSize,
+		UserMaxSize:       cfg.App.UserMaxSize,
+		MaxExpiration:     time.Duration(cfg.App.MaxExpiration) * time.Hour,
+		UserMaxExpiration: time.Duration(cfg.App.UserMaxExpiration) * time.Hour,
+		FileCacheMaxSize:  cfg.FileCache.MaxSize,
+		PasswordAttempts:  cfg.App.PasswordAttempts,
+		PasswordWindow:    time.Duration(cfg.App.PasswordWindow) * time.Minute,
 		DefaultExpiration: time.Duration(cfg.App.DefaultExpiration) * time.Hour,
 		ReadTimeout:       time.Duration(cfg.App.ReadTimeout) * time.Second,
 		WriteTimeout:      time.Duration(cfg.App.WriteTimeout) * time.Second,
@@ -96,10 +218,11 @@ func main() {
 		log.Panicf("Initialization failed: %s\n", err)
 	}
 
+	// The process exits once main returns, after the app and the tracer
+	// are shut down.
+	runCh := make(chan error, 1)
 	go func() {
-		err = a.Run()
-		logger.Info(fmt.Sprintf("App closed: %s", err))
-		os.Exit(0)
+		runCh <- a.Run()
 	}()
 
 	logger.Info("App started")
@@ -107,11 +230,32 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	logger.Info(fmt.Sprintf("Get signal: %s", <-sigCh))
+	select {
+	case sig := <-sigCh:
+		logger.Info(fmt.Sprintf("Get signal: %s", sig))
+	case runErr := <-runCh:
+		logger.Info(fmt.Sprintf("App closed: %s", runErr))
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
 	logger.Info("Stopping app gracefully")
 	logger.Info(fmt.Sprintf("App stopped: %s", a.Shutdown(ctx)))
+
+	if traceErr := shutdownTracing(ctx); traceErr != nil {
+		logger.Error("Cannot flush traces", l.ErrorAttr(traceErr))
+	}
+}
+
+// newLimiter returns nil when perMin is 0 so the limit is disabled.
+func newLimiter(client *redis.Client, prefix string, perMin, burst int, logger *slog.Logger) server.Limiter {
+	if perMin <= 0 {
+		return nil
+	}
+
+	return ratelimit.New(client, prefix, ratelimit.Config{
+		Rate:  float64(perMin) / 60,
+		Burst: max(burst, 1),
+	}, logger)
 }
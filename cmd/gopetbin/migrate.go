@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/db"
+)
+
+const migrateUsage = "usage: gopetbin migrate up|down|status"
+
+func runMigrate(repo db.Repository, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := repo.Migrator()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range applied {
+			fmt.Printf("Applied %04d_%s\n", v.Version, v.Name)
+		}
+
+		if len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+
+	case "down":
+		v, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Rolled back %04d_%s\n", v.Version, v.Name)
+
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range status {
+			state := "pending"
+			if v.Applied {
+				state = "applied " + v.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", v.Version, v.Name, state)
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -2,12 +2,20 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
 	"time"
 
+	"github.com/swmh/gopetbin/internal/cleaner"
+	l "github.com/swmh/gopetbin/internal/logger"
+	"github.com/swmh/gopetbin/internal/metrics"
 	"github.com/swmh/gopetbin/internal/server"
 	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/tracing"
 )
 
 type Config struct {
@@ -17,30 +25,96 @@ type Config struct {
 	Repo              service.Repository
 	Locker            service.Locker
 	Logger            *slog.Logger
+	Checks            map[string]server.Pinger /* dependencies reported by /readyz */
+	CheckTimeout      time.Duration
+	CreateLimiter     server.Limiter
+	ReadLimiter       server.Limiter
+	TrustedProxies    []netip.Prefix
+	InlineTypes       map[string]bool
+	MigrateBlobs      bool
+	Cleaner           *cleaner.Config /* nil leaves cleaning to the clean command */
+	CleanInterval     time.Duration
 	Addr              string
+	MetricsAddr       string /* empty disables metrics */
 	PublicPath        string
 	DefaultExpiration time.Duration
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
 	IDLength          int
 	MaxSize           int64
-	MaxFileMemory     int64
+	UserMaxSize       int64
+	MaxExpiration     time.Duration
+	UserMaxExpiration time.Duration
+	FileCacheMaxSize  int64
+	PasswordAttempts  int
+	PasswordWindow    time.Duration
 }
 
+// Blobs are migrated in batches, a failed batch is retried after a pause.
+const (
+	migrateBatch = 100
+	migrateRetry = time.Minute
+)
+
 type App struct {
-	server *server.Server
+	server  *server.Server
+	service *service.Service
+	metrics *http.Server
+	logger  *slog.Logger
+
+	migrateBlobs  bool
+	cleaner       *cleaner.Cleaner
+	cleanInterval time.Duration
+	background    context.Context /* canceled on Shutdown */
+	stop          context.CancelFunc
+	running       sync.WaitGroup /* background goroutines */
 }
 
 func New(c Config) (*App, error) {
+	c.Storage = tracing.Storage(c.Storage)
+	c.Repo = tracing.Repository(c.Repo)
+	c.Cache = tracing.Cache(c.Cache)
+	c.FileCache = tracing.FileCache(c.FileCache)
+	c.Locker = tracing.Locker(c.Locker)
+
+	var m *metrics.Metrics
+	var metricsServer *http.Server
+
+	if c.MetricsAddr != "" {
+		m = metrics.New()
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+
+		metricsServer = &http.Server{
+			Addr:     c.MetricsAddr,
+			Handler:  mux,
+			ErrorLog: slog.NewLogLogger(c.Logger.Handler(), slog.LevelInfo),
+		}
+
+		c.Storage = m.Storage(c.Storage)
+		c.Repo = m.Repository(c.Repo)
+		c.Cache = m.Cache(c.Cache)
+		c.FileCache = m.FileCache(c.FileCache)
+		c.Locker = m.Locker(c.Locker)
+	}
+
 	serviceConfig := service.Config{
-		Storage:       c.Storage,
-		Repo:          c.Repo,
-		Cache:         c.Cache,
-		FileCache:     c.FileCache,
-		Locker:        c.Locker,
-		Logger:        c.Logger,
-		IDLength:      c.IDLength,
-		DefaultExpire: c.DefaultExpiration,
+		Storage:          c.Storage,
+		Repo:             c.Repo,
+		Cache:            c.Cache,
+		FileCache:        c.FileCache,
+		Locker:           c.Locker,
+		Logger:           c.Logger,
+		IDLength:         c.IDLength,
+		DefaultExpire:    c.DefaultExpiration,
+		FileCacheMaxSize: c.FileCacheMaxSize,
+		PasswordAttempts: c.PasswordAttempts,
+		PasswordWindow:   c.PasswordWindow,
+	}
+
+	if m != nil {
+		serviceConfig.Metrics = m
 	}
 
 	srvc, err := service.New(serviceConfig)
@@ -55,19 +129,140 @@ func New(c Config) (*App, error) {
 		ReadTimeout:   c.ReadTimeout,
 		WriteTimout:   c.WriteTimeout,
 		MaxSize:       c.MaxSize,
-		MaxFileMemory: c.MaxFileMemory,
+		MaxExpire:     c.MaxExpiration,
+		UserMaxSize:   c.UserMaxSize,
+		UserMaxExpire: c.UserMaxExpiration,
 		PublicPath:    c.PublicPath,
+		Checks:        c.Checks,
+		CheckTimeout:  c.CheckTimeout,
+
+		CreateLimiter:  c.CreateLimiter,
+		ReadLimiter:    c.ReadLimiter,
+		TrustedProxies: c.TrustedProxies,
+
+		InlineTypes: c.InlineTypes,
+	}
+
+	if m != nil {
+		serverConfig.Observer = m
+	}
+
+	var clnr *cleaner.Cleaner
+
+	if c.Cleaner != nil {
+		cleanerConfig := *c.Cleaner
+		if m != nil {
+			cleanerConfig.Metrics = m
+		}
+
+		clnr = cleaner.New(cleanerConfig)
 	}
 
+	background, stop := context.WithCancel(context.Background())
+
 	return &App{
-		server.New(serverConfig),
+		server:        server.New(serverConfig),
+		service:       srvc,
+		metrics:       metricsServer,
+		logger:        c.Logger,
+		migrateBlobs:  c.MigrateBlobs,
+		cleaner:       clnr,
+		cleanInterval: c.CleanInterval,
+		background:    background,
+		stop:          stop,
 	}, nil
 }
 
 func (a *App) Run() error {
+	if a.metrics != nil {
+		go func() {
+			err := a.metrics.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.logger.Error("Metrics server stopped", l.ErrorAttr(err))
+			}
+		}()
+	}
+
+	if a.migrateBlobs {
+		a.goBackground(a.runMigrateBlobs)
+	}
+
+	if a.cleaner != nil {
+		a.goBackground(func(ctx context.Context) {
+			a.cleaner.Serve(ctx, a.cleanInterval)
+		})
+	}
+
 	return fmt.Errorf("server running error: %w", a.server.Run())
 }
 
+// goBackground runs f until Shutdown cancels the background context, which
+// waits for it to return.
+func (a *App) goBackground(f func(ctx context.Context)) {
+	a.running.Add(1)
+
+	go func() {
+		defer a.running.Done()
+		f(a.background)
+	}()
+}
+
+// runMigrateBlobs migrates blobs stored before checksums until none are
+// left. Replicas may run it at the same time, migrating a blob twice is
+// harmless.
+func (a *App) runMigrateBlobs(ctx context.Context) {
+	a.logger.Info("Migrating blobs")
+
+	var after string
+
+	for {
+		next, err := a.service.MigrateBlobs(ctx, after, migrateBatch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			a.logger.Error("Cannot migrate blobs", l.ErrorAttr(err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(migrateRetry):
+			}
+
+			continue
+		}
+
+		if next == "" {
+			a.logger.Info("Blobs migrated")
+			return
+		}
+
+		after = next
+	}
+}
+
 func (a *App) Shutdown(ctx context.Context) error {
-	return fmt.Errorf("server shutdown error: %w", a.server.Shutdown(ctx))
+	a.stop()
+
+	var err error
+	if a.metrics != nil {
+		err = a.metrics.Shutdown(ctx)
+	}
+
+	err = errors.Join(a.server.Shutdown(ctx), err)
+
+	stopped := make(chan struct{})
+	go func() {
+		a.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = errors.Join(err, fmt.Errorf("background tasks still running: %w", ctx.Err()))
+	}
+
+	return fmt.Errorf("server shutdown error: %w", err)
 }
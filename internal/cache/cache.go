@@ -13,10 +13,22 @@ import (
 )
 
 type Paste struct {
-	Name       string    `json:"name"`
-	Expire     time.Time `json:"expire"`
-	BurnAfter  int       `json:"burn_after"`
-	IsBurnable bool      `json:"is_burnable"`
+	Name        string    `json:"name"`
+	Expire      time.Time `json:"expire"`
+	BurnAfter   int       `json:"burn_after"`
+	IsBurnable  bool      `json:"is_burnable"`
+	DeleteToken string    `json:"delete_token"`
+	Revision    int       `json:"revision"`
+	Lang        string    `json:"lang"`
+	Password    string    `json:"password"`
+	Encrypted   bool      `json:"encrypted"`
+	Owner       int64     `json:"owner"`
+	Size        int64     `json:"size"`
+	CreatorIP   string    `json:"creator_ip"`
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename"`
+	Modified    time.Time `json:"modified"`
+	Checksum    string    `json:"checksum"`
 }
 
 func (p *Paste) UnmarshalBinary(data []byte) error {
@@ -82,3 +94,31 @@ func (c *CacheRedis) Set(ctx context.Context, key string, value service.Paste) e
 func (c *CacheRedis) Get(ctx context.Context, key string) (string, error) {
 	return c.client.Get(ctx, key).Result()
 }
+
+func (c *CacheRedis) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *CacheRedis) Attempts(ctx context.Context, key string) (int64, error) {
+	n, err := c.client.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+
+	return n, err
+}
+
+// AddAttempt increments the attempts counter, the counter expires ttl after
+// the first attempt.
+func (c *CacheRedis) AddAttempt(ctx context.Context, key string, ttl time.Duration) error {
+	pipe := c.client.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+func (c *CacheRedis) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
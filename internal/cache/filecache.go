@@ -57,3 +57,23 @@ func (c *FileCacheRedis) Get(ctx context.Context, key string) (io.ReadCloser, er
 	v, err := c.client.Get(ctx, key).Result()
 	return ToReadCloser{bytes.NewReader([]byte(v))}, err
 }
+
+// GetRange returns length bytes of the value starting at offset. Redis
+// answers a missing key with an empty string, so a short result is
+// reported as a miss.
+func (c *FileCacheRedis) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	v, err := c.client.GetRange(ctx, key, offset, offset+length-1).Result()
+	if err == nil && int64(len(v)) != length {
+		err = redis.Nil
+	}
+
+	return ToReadCloser{bytes.NewReader([]byte(v))}, err
+}
+
+func (c *FileCacheRedis) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *FileCacheRedis) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru keeps values up to a total size, evicting the least recently used
+// ones first. Values set with a ttl are dropped once it passes.
+type lru[V any] struct {
+	mu    sync.Mutex
+	max   int64
+	size  int64
+	order *list.List /* most recently used first */
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	size    int64
+	expires time.Time /* zero never expires */
+}
+
+func newLRU[V any](max int64) *lru[V] {
+	return &lru[V]{
+		max:   max,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lru[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	e := el.Value.(*lruEntry[V])
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(el)
+		return zero, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return e.value, true
+}
+
+// set stores value taking size of the total, values larger than the total
+// are not kept at all.
+func (c *lru[V]) set(key string, value V, size int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	if size > c.max {
+		return
+	}
+
+	e := &lruEntry[V]{key: key, value: value, size: size}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	c.items[key] = c.order.PushFront(e)
+	c.size += size
+
+	for c.size > c.max {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lru[V]) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *lru[V]) remove(el *list.Element) {
+	e := c.order.Remove(el).(*lruEntry[V])
+	delete(c.items, e.key)
+	c.size -= e.size
+}
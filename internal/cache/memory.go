@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/service"
+)
+
+var errMiss = errors.New("not in cache")
+
+// Defaults of the in-process caches.
+const (
+	DefaultMemorySize     = 10000    /* pastes */
+	DefaultFileMemorySize = 64 << 20 /* bytes */
+)
+
+// CacheMemory keeps pastes in process, for single node installs without
+// Redis. The least recently used pastes are evicted first.
+type CacheMemory struct {
+	values *lru[string]
+
+	mu       sync.Mutex
+	attempts map[string]attempts
+	calls    int
+}
+
+type attempts struct {
+	n       int64
+	expires time.Time
+}
+
+// NewMemory keeps up to size pastes, 0 uses DefaultMemorySize.
+func NewMemory(size int) *CacheMemory {
+	if size <= 0 {
+		size = DefaultMemorySize
+	}
+
+	return &CacheMemory{
+		values:   newLRU[string](int64(size)),
+		attempts: make(map[string]attempts),
+	}
+}
+
+func (c *CacheMemory) IsNoSuchPaste(err error) bool {
+	return errors.Is(err, errMiss)
+}
+
+func (c *CacheMemory) Unmarshal(_ context.Context, value string) (service.Paste, error) {
+	var paste Paste
+	err := json.Unmarshal([]byte(value), &paste)
+	return service.Paste(paste), err
+}
+
+func (c *CacheMemory) IsError(_ context.Context, value string) bool {
+	return value == errorValue
+}
+
+func (c *CacheMemory) SetError(_ context.Context, key string, ttl time.Duration) error {
+	c.values.set(key, errorValue, 1, ttl)
+	return nil
+}
+
+func (c *CacheMemory) Set(_ context.Context, key string, value service.Paste) error {
+	v, err := json.Marshal(Paste(value))
+	if err != nil {
+		return err
+	}
+
+	c.values.set(key, string(v), 1, 0)
+
+	return nil
+}
+
+func (c *CacheMemory) Get(_ context.Context, key string) (string, error) {
+	v, ok := c.values.get(key)
+	if !ok {
+		return "", errMiss
+	}
+
+	return v, nil
+}
+
+func (c *CacheMemory) Delete(_ context.Context, key string) error {
+	c.values.delete(key)
+	return nil
+}
+
+func (c *CacheMemory) Attempts(_ context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a, ok := c.attempts[key]
+	if !ok || time.Now().After(a.expires) {
+		return 0, nil
+	}
+
+	return a.n, nil
+}
+
+const sweepEvery = 1024
+
+// AddAttempt increments the attempts counter, the counter expires ttl after
+// the first attempt.
+func (c *CacheMemory) AddAttempt(_ context.Context, key string, ttl time.Duration) error {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+	if c.calls%sweepEvery == 0 {
+		for k, a := range c.attempts {
+			if now.After(a.expires) {
+				delete(c.attempts, k)
+			}
+		}
+	}
+
+	a, ok := c.attempts[key]
+	if !ok || now.After(a.expires) {
+		a = attempts{expires: now.Add(ttl)}
+	}
+
+	a.n++
+	c.attempts[key] = a
+
+	return nil
+}
+
+// FileCacheMemory keeps content in process up to a total size, for single
+// node installs without Redis.
+type FileCacheMemory struct {
+	values *lru[[]byte]
+}
+
+// NewFileCacheMemory keeps up to size bytes, 0 uses DefaultFileMemorySize.
+func NewFileCacheMemory(size int64) *FileCacheMemory {
+	if size <= 0 {
+		size = DefaultFileMemorySize
+	}
+
+	return &FileCacheMemory{
+		values: newLRU[[]byte](size),
+	}
+}
+
+func (c *FileCacheMemory) IsNoSuchPaste(err error) bool {
+	return errors.Is(err, errMiss)
+}
+
+func (c *FileCacheMemory) Set(_ context.Context, key string, value []byte) error {
+	c.values.set(key, value, int64(len(value)), 0)
+	return nil
+}
+
+func (c *FileCacheMemory) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	v, ok := c.values.get(key)
+	if !ok {
+		return nil, errMiss
+	}
+
+	return ToReadCloser{bytes.NewReader(v)}, nil
+}
+
+// GetRange returns length bytes of the value starting at offset, a range
+// past the end is reported as a miss like FileCacheRedis does.
+func (c *FileCacheMemory) GetRange(_ context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	v, ok := c.values.get(key)
+	if !ok || offset+length > int64(len(v)) {
+		return nil, errMiss
+	}
+
+	return ToReadCloser{bytes.NewReader(v[offset : offset+length])}, nil
+}
+
+func (c *FileCacheMemory) Delete(_ context.Context, key string) error {
+	c.values.delete(key)
+	return nil
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFileCacheMemoryEvicts(t *testing.T) {
+	ctx := context.Background()
+	c := NewFileCacheMemory(8)
+
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{key: "a", value: "aaaa"},
+		{key: "b", value: "bbbb"},
+		{key: "c", value: "cccc"},
+		{key: "huge", value: "larger than the cache"},
+	}
+
+	for _, tt := range tests {
+		if err := c.Set(ctx, tt.key, []byte(tt.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for key, want := range map[string]string{"a": "", "b": "bbbb", "c": "cccc", "huge": ""} {
+		f, err := c.Get(ctx, key)
+		if want == "" {
+			if !c.IsNoSuchPaste(err) {
+				t.Errorf("get %s error is %v, want a miss", key, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("get %s: %s", key, err)
+		}
+
+		if got, _ := io.ReadAll(f); string(got) != want {
+			t.Errorf("get %s is %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestCacheMemoryExpires(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(0)
+
+	if err := c.SetError(ctx, "gone", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddAttempt(ctx, "attempts", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if _, err := c.Get(ctx, "gone"); !c.IsNoSuchPaste(err) {
+		t.Errorf("get error is %v, want a miss", err)
+	}
+
+	if n, err := c.Attempts(ctx, "attempts"); err != nil || n != 0 {
+		t.Errorf("attempts are %d, %v, want 0", n, err)
+	}
+}
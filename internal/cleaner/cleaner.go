@@ -0,0 +1,389 @@
+// Package cleaner removes expired pastes and the stored blobs no paste
+// refers to anymore.
+//
+// Uploads and the cleaner meet on the blob row of the repository: an upload
+// takes a reference and stores the content again if the row was gone, the
+// cleaner deletes the row only while it has no references and removes the
+// object before committing. An upload racing the cleaner either keeps the
+// blob alive or waits for the removal and stores its content afresh, so a
+// new paste never points at a deleted object.
+//
+// Expired and burned pastes are kept for a grace period longer than the
+// write timeout: a read started before the paste expired may still stream
+// its blob, the reference is only dropped once it is done.
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+	"github.com/swmh/gopetbin/internal/service"
+)
+
+var (
+	errLocked   = errors.New("another cleaner is running")
+	errLockLost = errors.New("cleaner lock lost")
+)
+
+// IsLocked reports whether Run was skipped because another cleaner holds
+// the lock.
+func IsLocked(err error) bool {
+	return errors.Is(err, errLocked)
+}
+
+// lockID is kept apart from paste ids, which never contain a colon.
+const lockID = "cleaner:run"
+
+const (
+	lockTimeout   = time.Second
+	lockRefresh   = 2 * time.Second
+	queryTimeout  = 10 * time.Second
+	deleteTimeout = 5 * time.Second
+
+	defaultBatch   = 100
+	defaultWorkers = 4
+
+	// graceMargin is added to the write timeout, defaultGrace is used when
+	// writes do not time out.
+	graceMargin  = time.Minute
+	defaultGrace = time.Hour
+)
+
+type Repository interface {
+	// DeleteExpired deletes up to limit released pastes or pastes expired
+	// before the given time, dropping their references, and returns how many
+	// of them had expired and how many were burned by their last read.
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (expired, burned int, err error)
+	// UnreferencedBlobs returns up to limit blobs without references,
+	// ordered by name after the given one.
+	UnreferencedBlobs(ctx context.Context, after string, limit int) ([]string, error)
+	// DeleteBlob deletes the blob if it is still unreferenced, calling remove
+	// while uploads cannot take it, and fails with a no such paste error
+	// otherwise.
+	DeleteBlob(ctx context.Context, name string, remove service.BlobFunc) error
+	service.NoSuchPasteChecker
+}
+
+// refresher is a Mutex that lapses unless it is refreshed, like the Redis
+// one.
+type refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+type Storage interface {
+	DeleteFile(ctx context.Context, name string) error
+	service.NoSuchPasteChecker
+}
+
+// Metrics counts the expired pastes the cleaner deleted as
+// service.EventExpire. Burned pastes were counted when they were read.
+type Metrics interface {
+	PasteEvents(event string, n int)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) PasteEvents(string, int) {}
+
+type Config struct {
+	Repo    Repository
+	Storage Storage
+	Locker  service.Locker
+	Logger  *slog.Logger
+	Metrics Metrics /* optional */
+	Batch   int     /* pastes and blobs per query, 0 uses the default */
+	Workers int     /* blobs deleted at once, 0 uses the default */
+
+	LockRefresh time.Duration /* how often an expiring lock is extended, 0 uses the default */
+
+	WriteTimeout time.Duration /* longest response write, expired pastes are kept longer */
+}
+
+// Result counts what a run deleted.
+type Result struct {
+	Pastes  int /* expired and burned */
+	Expired int
+	Blobs   int
+}
+
+type Cleaner struct {
+	repo    Repository
+	storage Storage
+	locker  service.Locker
+	logger  *slog.Logger
+	metrics Metrics
+	batch   int
+	workers int
+	grace   time.Duration
+	refresh time.Duration
+}
+
+func New(c Config) *Cleaner {
+	if c.Batch <= 0 {
+		c.Batch = defaultBatch
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+
+	if c.Metrics == nil {
+		c.Metrics = nopMetrics{}
+	}
+
+	if c.LockRefresh <= 0 {
+		c.LockRefresh = lockRefresh
+	}
+
+	grace := defaultGrace
+	if c.WriteTimeout > 0 {
+		grace = c.WriteTimeout + graceMargin
+	}
+
+	return &Cleaner{
+		repo:    c.Repo,
+		storage: c.Storage,
+		locker:  c.Locker,
+		logger:  c.Logger,
+		metrics: c.Metrics,
+		batch:   c.Batch,
+		workers: c.Workers,
+		grace:   grace,
+		refresh: c.LockRefresh,
+	}
+}
+
+// Serve runs the cleaner every interval until ctx is canceled. Replicas
+// share the lock, only the one holding it cleans in a given round.
+func (c *Cleaner) Serve(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res, err := c.Run(ctx)
+
+		switch {
+		case IsLocked(err):
+			c.logger.Debug("Cleaning skipped", l.ErrorAttr(err))
+		case err != nil && ctx.Err() == nil:
+			c.logger.Error("Cannot clean", l.ErrorAttr(err))
+		case err == nil:
+			c.logger.Info("Cleaned", slog.Int("pastes", res.Pastes), slog.Int("expired", res.Expired), slog.Int("blobs", res.Blobs))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run deletes expired pastes and then every unreferenced blob, a batch at a
+// time. Blobs that cannot be deleted are logged and left for the next run.
+//
+// The lock is extended while the run lasts and the run stops if it is lost.
+// It only saves replicas from doing the same work: deleting a blob is
+// claimed in the repository either way.
+func (c *Cleaner) Run(ctx context.Context) (res Result, err error) {
+	lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+
+	mutex, err := c.locker.Lock(lockCtx, lockID)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", errLocked, err)
+	}
+
+	defer func() {
+		if err := mutex.Unlock(context.WithoutCancel(ctx)); err != nil {
+			c.logger.Warn("Cannot unlock cleaner", l.ErrorAttr(err))
+		}
+	}()
+
+	if r, ok := mutex.(refresher); ok {
+		var lost context.CancelCauseFunc
+		ctx, lost = context.WithCancelCause(ctx)
+		defer lost(nil)
+
+		defer c.keepLocked(ctx, r, lost)()
+
+		defer func() {
+			if cause := context.Cause(ctx); err != nil && errors.Is(cause, errLockLost) {
+				err = cause
+			}
+		}()
+	}
+
+	res.Expired, res.Pastes, err = c.deleteExpired(ctx)
+	c.metrics.PasteEvents(service.EventExpire, res.Expired)
+
+	if err != nil {
+		return res, err
+	}
+
+	res.Blobs, err = c.deleteBlobs(ctx)
+
+	return res, err
+}
+
+// keepLocked refreshes the lock until the returned function is called and
+// calls lost once it cannot.
+func (c *Cleaner) keepLocked(ctx context.Context, r refresher, lost context.CancelCauseFunc) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(c.refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			refreshCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+			err := r.Refresh(refreshCtx)
+			cancel()
+
+			if err != nil {
+				lost(fmt.Errorf("%w: %w", errLockLost, err))
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// deleteExpired returns how many expired pastes and how many pastes in all
+// it deleted.
+func (c *Cleaner) deleteExpired(ctx context.Context) (int, int, error) {
+	var expired, deleted int
+
+	before := time.Now().Add(-c.grace)
+
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+		e, r, err := c.repo.DeleteExpired(queryCtx, before, c.batch)
+		cancel()
+
+		if err != nil {
+			return expired, deleted, fmt.Errorf("cannot delete expired pastes from repo: %w", err)
+		}
+
+		expired += e
+		deleted += e + r
+
+		if e+r < c.batch {
+			return expired, deleted, nil
+		}
+	}
+}
+
+func (c *Cleaner) deleteBlobs(ctx context.Context) (int, error) {
+	var (
+		deleted int
+		after   string
+	)
+
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, queryTimeout)
+		names, err := c.repo.UnreferencedBlobs(queryCtx, after, c.batch)
+		cancel()
+
+		if err != nil {
+			return deleted, fmt.Errorf("cannot get unreferenced blobs from repo: %w", err)
+		}
+
+		deleted += c.deleteAll(ctx, names)
+
+		if err = ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		if len(names) < c.batch {
+			return deleted, nil
+		}
+
+		after = names[len(names)-1]
+	}
+}
+
+// deleteAll deletes names with up to c.workers at once and returns how many
+// were deleted.
+func (c *Cleaner) deleteAll(ctx context.Context, names []string) int {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		deleted int
+	)
+
+	sem := make(chan struct{}, c.workers)
+
+	for _, name := range names {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if c.delete(ctx, name) {
+				mu.Lock()
+				deleted++
+				mu.Unlock()
+			}
+		}(name)
+	}
+
+	wg.Wait()
+
+	return deleted
+}
+
+func (c *Cleaner) delete(ctx context.Context, name string) bool {
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
+	err := c.repo.DeleteBlob(ctx, name, c.remove(name))
+	if err != nil {
+		if c.repo.IsNoSuchPaste(err) {
+			c.logger.Info("Blob referenced again", slog.String("name", name))
+			return false
+		}
+
+		c.logger.Warn("Cannot delete blob", slog.String("name", name), l.ErrorAttr(err))
+
+		return false
+	}
+
+	c.logger.Debug("Blob deleted", slog.String("name", name))
+
+	return true
+}
+
+func (c *Cleaner) remove(name string) service.BlobFunc {
+	return func(ctx context.Context) error {
+		if err := c.storage.DeleteFile(ctx, name); err != nil && !c.storage.IsNoSuchPaste(err) {
+			return fmt.Errorf("cannot delete file from storage: %w", err)
+		}
+
+		return nil
+	}
+}
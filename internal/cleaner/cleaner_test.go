@@ -0,0 +1,412 @@
+package cleaner_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/cleaner"
+	"github.com/swmh/gopetbin/internal/db/sqlite"
+	"github.com/swmh/gopetbin/internal/lock/mapmutex"
+	"github.com/swmh/gopetbin/internal/server"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+)
+
+// repo calls listed once the cleaner has listed unreferenced blobs and
+// deletes pastes as if it was later by ahead.
+type repo struct {
+	*sqlite.DB
+	listed func()
+	ahead  time.Duration
+}
+
+func (r *repo) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, int, error) {
+	return r.DB.DeleteExpired(ctx, before.Add(r.ahead), limit)
+}
+
+func (r *repo) UnreferencedBlobs(ctx context.Context, after string, limit int) ([]string, error) {
+	names, err := r.DB.UnreferencedBlobs(ctx, after, limit)
+	if err == nil && r.listed != nil {
+		r.listed()
+	}
+
+	return names, err
+}
+
+// uploads closes uploaded once the service has stored content under its
+// temporary name.
+type uploads struct {
+	*memory.Storage
+	uploaded chan struct{}
+}
+
+func (u *uploads) PutFile(ctx context.Context, name string, r io.Reader, size int64, codec string) error {
+	err := u.Storage.PutFile(ctx, name, r, size, codec)
+	if u.uploaded != nil && strings.HasPrefix(name, "tmp/") {
+		close(u.uploaded)
+	}
+
+	return err
+}
+
+// gated blocks removals until proceed is closed and closes removed once the
+// object is gone.
+type gated struct {
+	*memory.Storage
+	removing chan struct{}
+	proceed  chan struct{}
+	removed  chan struct{}
+}
+
+func (g *gated) DeleteFile(ctx context.Context, name string) error {
+	close(g.removing)
+	<-g.proceed
+
+	defer close(g.removed)
+
+	return g.Storage.DeleteFile(ctx, name)
+}
+
+type env struct {
+	repo    *repo
+	storage *uploads
+	service *service.Service
+}
+
+func newEnv(t *testing.T) env {
+	t.Helper()
+
+	e := env{repo: &repo{DB: servicetest.NewRepo(t)}, storage: &uploads{Storage: memory.New()}}
+
+	// Content is always read from storage, so a deleted blob shows.
+	e.service = servicetest.NewService(t, service.Config{
+		Storage:          e.storage,
+		Repo:             e.repo,
+		FileCacheMaxSize: -1,
+	})
+
+	return e
+}
+
+func (e env) cleaner(s cleaner.Storage, batch, workers int) *cleaner.Cleaner {
+	return e.cleanerWith(s, batch, workers, nil)
+}
+
+func (e env) cleanerWith(s cleaner.Storage, batch, workers int, m cleaner.Metrics) *cleaner.Cleaner {
+	return cleaner.New(cleaner.Config{
+		Metrics:      m,
+		Repo:         e.repo,
+		Storage:      s,
+		Locker:       mapmutex.New[string](),
+		Logger:       servicetest.Logger(),
+		Batch:        batch,
+		Workers:      workers,
+		WriteTimeout: time.Minute,
+	})
+}
+
+func (e env) create(ctx context.Context, content string, burnAfter int) (string, error) {
+	info, err := e.service.CreatePaste(ctx, server.Paste{
+		Content:     strings.NewReader(content),
+		Size:        int64(len(content)),
+		ContentType: "application/octet-stream",
+		BurnAfter:   burnAfter,
+	})
+
+	return info.ID, err
+}
+
+// orphan creates a paste and deletes it in the repo only, leaving its blob
+// unreferenced in the storage.
+func (e env) orphan(t *testing.T, content string) {
+	t.Helper()
+
+	id, err := e.create(context.Background(), content, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = e.repo.DeletePaste(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (e env) content(t *testing.T, id string) string {
+	t.Helper()
+
+	_, f, err := e.service.GetPaste(context.Background(), server.Query{ID: id})
+	if err != nil {
+		t.Fatalf("paste %s is gone: %s", id, err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("content of paste %s is gone: %s", id, err)
+	}
+
+	return string(b)
+}
+
+func TestReferencedAfterListing(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	e.orphan(t, "content")
+
+	// A paste takes the blob after the cleaner has listed it.
+	var id string
+	e.repo.listed = func() {
+		e.repo.listed = nil
+
+		var err error
+		if id, err = e.create(ctx, "content", 0); err != nil {
+			t.Error(err)
+		}
+	}
+
+	res, err := e.cleaner(e.storage, 0, 0).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Blobs != 0 {
+		t.Errorf("deleted %d blobs, want 0", res.Blobs)
+	}
+
+	if got := e.content(t, id); got != "content" {
+		t.Errorf("paste content is %q, want %q", got, "content")
+	}
+}
+
+func TestCreateDuringRemoval(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+	e.orphan(t, "content")
+
+	g := &gated{
+		Storage:  e.storage.Storage,
+		removing: make(chan struct{}),
+		proceed:  make(chan struct{}),
+		removed:  make(chan struct{}),
+	}
+
+	var (
+		res cleaner.Result
+		err error
+		wg  sync.WaitGroup
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		res, err = e.cleaner(g, 0, 0).Run(ctx)
+	}()
+
+	<-g.removing
+
+	// A paste with the same content arrives while the object is removed.
+	e.storage.uploaded = make(chan struct{})
+
+	type created struct {
+		id  string
+		err error
+	}
+
+	done := make(chan created, 1)
+	go func() {
+		id, err := e.create(ctx, "content", 0)
+
+		select {
+		case <-g.removed:
+		default:
+			err = fmt.Errorf("paste created before the removal finished: %v", err)
+		}
+
+		done <- created{id, err}
+	}()
+
+	<-e.storage.uploaded
+	close(g.proceed)
+	wg.Wait()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := <-done
+	if c.err != nil {
+		t.Fatal(c.err)
+	}
+
+	if res.Blobs != 1 {
+		t.Errorf("deleted %d blobs, want 1", res.Blobs)
+	}
+
+	if got := e.content(t, c.id); got != "content" {
+		t.Errorf("paste content is %q, want %q", got, "content")
+	}
+
+	if res, err = e.cleaner(e.storage, 0, 0).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Blobs != 0 {
+		t.Errorf("deleted %d referenced blobs", res.Blobs)
+	}
+}
+
+func TestRunPages(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+
+	for _, content := range []string{"a", "b", "c", "d", "e"} {
+		e.orphan(t, content)
+	}
+
+	id, err := e.create(ctx, "kept", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := e.cleaner(e.storage, 2, 2).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Blobs != 5 {
+		t.Errorf("deleted %d blobs, want 5", res.Blobs)
+	}
+
+	if got := e.content(t, id); got != "kept" {
+		t.Errorf("paste content is %q, want %q", got, "kept")
+	}
+}
+
+func TestBurnedKept(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+
+	id, err := e.create(ctx, "content", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, f, err := e.service.GetPaste(ctx, server.Query{ID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The last read is still streaming when the cleaner runs.
+	res, err := e.cleaner(e.storage, 0, 0).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res != (cleaner.Result{}) {
+		t.Errorf("deleted %+v, want nothing", res)
+	}
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "content" {
+		t.Errorf("paste content is %q, want %q", b, "content")
+	}
+}
+
+// lapsing hands out mutexes that cannot be refreshed.
+type lapsing struct{}
+
+func (lapsing) Lock(context.Context, string) (service.Mutex, error) {
+	return lapsed{}, nil
+}
+
+type lapsed struct{}
+
+func (lapsed) Unlock(context.Context) error { return nil }
+
+func (lapsed) Refresh(context.Context) error { return errors.New("lock expired") }
+
+// stalled holds removals until the run is stopped.
+type stalled struct {
+	*memory.Storage
+}
+
+func (stalled) DeleteFile(ctx context.Context, _ string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestLockLost(t *testing.T) {
+	e := newEnv(t)
+	e.orphan(t, "content")
+
+	c := cleaner.New(cleaner.Config{
+		Repo:        e.repo,
+		Storage:     stalled{e.storage.Storage},
+		Locker:      lapsing{},
+		Logger:      servicetest.Logger(),
+		LockRefresh: time.Millisecond,
+	})
+
+	res, err := c.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "lock expired") {
+		t.Errorf("run ended with %v, want the lock error", err)
+	}
+
+	if res.Blobs != 0 {
+		t.Errorf("deleted %d blobs, want 0", res.Blobs)
+	}
+}
+
+type events map[string]int
+
+func (e events) PasteEvents(event string, n int) {
+	e[event] += n
+}
+
+func TestBurnedNotExpired(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t)
+
+	id, err := e.create(ctx, "burned", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := e.content(t, id); got != "burned" {
+		t.Fatalf("paste content is %q, want %q", got, "burned")
+	}
+
+	if _, err = e.create(ctx, "expired", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both pastes are past their grace period.
+	e.repo.ahead = 2 * time.Hour
+
+	m := events{}
+
+	res, err := e.cleanerWith(e.storage, 0, 0, m).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Pastes != 2 || res.Expired != 1 {
+		t.Errorf("deleted %d pastes, %d expired, want 2 pastes, 1 expired", res.Pastes, res.Expired)
+	}
+
+	if m[service.EventExpire] != 1 {
+		t.Errorf("counted %d expired pastes, want 1", m[service.EventExpire])
+	}
+}
@@ -20,44 +20,89 @@ type Config struct {
 		WriteTimeout      int    `mapstructure:"timeout_write"`
 		LogLevel          string `mapstructure:"log_level"` /* debug, info, warn, error */
 		PublicPath        string `mapstructure:"public_path"`
-		MaxFileMemory     int64  `mapstructure:"max_file_memory"`    /* maximum bytes of file data stored in memory */
-		DefaultExpiration int    `mapstructure:"default_expiration"` /* hours */
+		DefaultExpiration int    `mapstructure:"default_expiration"`  /* hours */
+		PasswordAttempts  int    `mapstructure:"password_attempts"`   /* wrong password attempts per paste before it is locked, 0 uses 5, -1 disables the limit */
+		PasswordWindow    int    `mapstructure:"password_window"`     /* minutes, 0 uses 15 */
+		CheckTimeout      int    `mapstructure:"check_timeout"`       /* milliseconds per dependency in /readyz */
+		MaxExpiration     int    `mapstructure:"max_expiration"`      /* hours, 0 is unlimited */
+		UserMaxSize       int64  `mapstructure:"user_max_size"`       /* max paste size in bytes with an API key */
+		UserMaxExpiration int    `mapstructure:"user_max_expiration"` /* hours with an API key, 0 is unlimited */
+		InlineTypes       string `mapstructure:"inline_types"`        /* comma separated MIME types served inline, empty uses the defaults */
+		MigrateBlobs      bool   `mapstructure:"migrate_blobs"`       /* checksum blobs stored before checksums and rename md5 named ones in the background */
 	} `mapstructure:"app"`
 
 	DB struct {
-		Addr string `mapstructure:"addr"`
-		User string `mapstructure:"user"`
-		Pass string `mapstructure:"pass"`
-		Name string `mapstructure:"name"`
+		Driver string `mapstructure:"driver"` /* postgres, sqlite */
+		Path   string `mapstructure:"path"`   /* database file of sqlite driver */
+		Addr   string `mapstructure:"addr"`
+		User   string `mapstructure:"user"`
+		Pass   string `mapstructure:"pass"`
+		Name   string `mapstructure:"name"`
+
+		AutoMigrate bool `mapstructure:"auto_migrate"` /* apply pending migrations on start */
 	} `mapstructure:"db"`
 
 	Storage struct {
-		Addr string `mapstructure:"addr"`
-		User string `mapstructure:"user"`
-		Pass string `mapstructure:"pass"`
-		Name string `mapstructure:"name"`
+		Driver string `mapstructure:"driver"` /* minio, fs, memory */
+		Path   string `mapstructure:"path"`   /* root directory of fs driver */
+		Addr   string `mapstructure:"addr"`
+		User   string `mapstructure:"user"`
+		Pass   string `mapstructure:"pass"`
+		Name   string `mapstructure:"name"`
 	} `mapstructure:"storage"`
 
 	Cache struct {
-		Addr string `mapstructure:"addr"`
+		Addr string `mapstructure:"addr"` /* empty keeps pastes in memory, for a single node */
 		User string `mapstructure:"user"`
 		Pass string `mapstructure:"pass"`
 		DB   int    `mapstructure:"db"`
+		Size int    `mapstructure:"size"` /* pastes kept in memory without addr, 0 uses 10000 */
 	} `mapstructure:"cache"`
 
 	FileCache struct {
-		Addr string `mapstructure:"addr"`
-		User string `mapstructure:"user"`
-		Pass string `mapstructure:"pass"`
-		DB   int    `mapstructure:"db"`
+		Addr       string `mapstructure:"addr"` /* empty keeps content in memory, for a single node */
+		User       string `mapstructure:"user"`
+		Pass       string `mapstructure:"pass"`
+		DB         int    `mapstructure:"db"`
+		MaxSize    int64  `mapstructure:"max_size"`    /* max paste size in bytes kept in file cache, 0 uses 10 MiB, -1 disables caching */
+		MemorySize int64  `mapstructure:"memory_size"` /* bytes kept in memory without addr, 0 uses 64 MiB */
 	} `mapstructure:"file_cache"`
 
+	RateLimit struct {
+		Addr           string `mapstructure:"addr"` /* redis shared by replicas, empty keeps limits in memory */
+		User           string `mapstructure:"user"`
+		Pass           string `mapstructure:"pass"`
+		DB             int    `mapstructure:"db"`
+		CreatePerMin   int    `mapstructure:"create_per_min"` /* creates and updates per client IP per minute, 0 disables */
+		CreateBurst    int    `mapstructure:"create_burst"`
+		ReadPerMin     int    `mapstructure:"read_per_min"` /* reads per client IP per minute, 0 disables */
+		ReadBurst      int    `mapstructure:"read_burst"`
+		TrustedProxies string `mapstructure:"trusted_proxies"` /* comma separated IPs and CIDRs allowed to set X-Forwarded-For */
+	} `mapstructure:"rate_limit"`
+
+	Metrics struct {
+		Addr string `mapstructure:"addr"` /* listen address of /metrics, empty disables metrics */
+	} `mapstructure:"metrics"`
+
+	Tracing struct {
+		Exporter string `mapstructure:"exporter"` /* none, stdout, otlp */
+		Endpoint string `mapstructure:"endpoint"` /* OTLP/HTTP collector host:port */
+		Insecure bool   `mapstructure:"insecure"` /* send to the collector over plain HTTP */
+		Path     string `mapstructure:"path"`     /* file of stdout exporter, empty writes to stdout */
+	} `mapstructure:"tracing"`
+
 	Locker struct {
-		Addr string `mapstructure:"addr"`
+		Addr string `mapstructure:"addr"` /* empty locks in process, for a single node */
 		User string `mapstructure:"user"`
 		Pass string `mapstructure:"pass"`
 		DB   int    `mapstructure:"db"`
 	} `mapstructure:"locker"`
+
+	Clean struct {
+		Interval int `mapstructure:"interval"` /* seconds between cleanups run by the app, 0 leaves cleaning to the clean command */
+		Batch    int `mapstructure:"batch"`    /* pastes and blobs per query, 0 uses the default */
+		Workers  int `mapstructure:"workers"`  /* blobs deleted in parallel, 0 uses the default */
+	} `mapstructure:"clean"`
 }
 
 func New(path string) (*Config, error) {
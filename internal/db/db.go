@@ -3,25 +3,70 @@ package db
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
+	"strings"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
+	"github.com/swmh/gopetbin/internal/db/migrate"
 	"github.com/swmh/gopetbin/internal/service"
 	"github.com/swmh/gopetbin/pkg/retry"
 )
 
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockID is the key of the advisory lock that keeps replicas from
+// running migrations concurrently.
+const migrationLockID = 0x676f706574
+
+type advisoryLock struct{}
+
+func (advisoryLock) Lock(ctx context.Context, conn *sqlx.Conn) error {
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID)
+	return err
+}
+
+func (advisoryLock) Unlock(ctx context.Context, conn *sqlx.Conn) error {
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	return err
+}
+
 type DB struct {
 	db *sqlx.DB
 }
 
 type Paste struct {
-	ID             string        `db:"id"`
-	Name           string        `db:"name"`
-	ExpireAt       time.Time     `db:"expire_at"`
-	RemainingReads sql.NullInt64 `db:"remaining_reads"`
+	ID             string         `db:"id"`
+	Name           string         `db:"name"`
+	ExpireAt       time.Time      `db:"expire_at"`
+	RemainingReads sql.NullInt64  `db:"remaining_reads"`
+	DeleteToken    sql.NullString `db:"delete_token"`
+	Revision       int            `db:"revision"`
+	Lang           sql.NullString `db:"lang"`
+	Password       sql.NullString `db:"password"`
+	Encrypted      bool           `db:"encrypted"`
+	OwnerID        sql.NullInt64  `db:"owner_id"`
+	Size           sql.NullInt64  `db:"size"`
+	CreatorIP      sql.NullString `db:"creator_ip"`
+	ContentType    sql.NullString `db:"content_type"`
+	Filename       sql.NullString `db:"filename"`
+	ModifiedAt     sql.NullTime   `db:"modified_at"`
+	Checksum       sql.NullString `db:"checksum"`
+}
+
+const pasteColumns = `id, name, expire_at, remaining_reads, delete_token, revision, lang, password, encrypted,
+	owner_id, size, creator_ip, content_type, filename, checksum,
+	COALESCE((SELECT r.created_at FROM paste_revisions r WHERE r.paste_id = pastes.id AND r.revision = pastes.revision),
+		pastes.created_at) AS modified_at`
+
+type ListedPaste struct {
+	Paste
+	CreatedAt time.Time `db:"created_at"`
 }
 
 func New(address, user, password, dbname string) (*DB, error) {
@@ -44,74 +89,504 @@ func New(address, user, password, dbname string) (*DB, error) {
 	return &DB{db}, nil
 }
 
+func (d *DB) Migrator() (*migrate.Migrator, error) {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(d.db, sub, advisoryLock{})
+}
+
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt64(i int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: i, Valid: i != 0}
+}
+
 func (d *DB) IsNoSuchPaste(err error) bool {
 	return errors.Is(err, sql.ErrNoRows)
 }
 
-func (d *DB) CreatePaste(ctx context.Context, id string, name string, expire time.Time, burn int) error {
+func (d *DB) CreatePaste(ctx context.Context, id string, paste service.Paste, place service.BlobFunc) error {
 	var remainingReads sql.NullInt64
-	if burn > 0 {
+	if paste.IsBurnable {
 		remainingReads = sql.NullInt64{
-			Int64: int64(burn),
+			Int64: int64(paste.BurnAfter),
 			Valid: true,
 		}
 	}
 
-	_, err := d.db.ExecContext(ctx, `INSERT INTO pastes (id, name, expire_at, remaining_reads)
-									VALUES ($1, $2, $3, $4)`, id, name, expire, remainingReads)
-	return err
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO pastes (id, name, expire_at, remaining_reads, delete_token, lang, password, encrypted,
+									owner_id, size, creator_ip, content_type, filename, checksum) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		id, paste.Name, paste.Expire, remainingReads, paste.DeleteToken, nullString(paste.Lang),
+		nullString(paste.Password), paste.Encrypted, nullInt64(paste.Owner), paste.Size, nullString(paste.CreatorIP),
+		nullString(paste.ContentType), nullString(paste.Filename), nullString(paste.Checksum))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO paste_revisions (paste_id, revision, name, checksum) VALUES ($1, 1, $2, $3)`,
+		id, paste.Name, nullString(paste.Checksum))
+	if err != nil {
+		return err
+	}
+
+	if err = acquire(ctx, tx, paste.Name, 1, paste.Size, place); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// acquire takes refs references to the blob and calls place if it was not
+// referenced before. The row stays locked until the transaction ends, so
+// the cleaner cannot delete the blob while it is placed.
+func acquire(ctx context.Context, tx *sqlx.Tx, name string, refs int, size int64, place service.BlobFunc) error {
+	var total int
+
+	err := tx.QueryRowxContext(ctx, `INSERT INTO blobs (name, refs, size) VALUES ($1, $2, $3)
+									ON CONFLICT (name) DO UPDATE SET refs = blobs.refs + excluded.refs,
+										size = COALESCE(blobs.size, excluded.size)
+									RETURNING refs`, name, refs, nullInt64(size)).Scan(&total)
+	if err != nil {
+		return err
+	}
+
+	if total > refs {
+		return nil
+	}
+
+	return place(ctx)
+}
+
+// release drops the references of every revision of the paste and returns
+// the blobs no paste refers to anymore.
+func release(ctx context.Context, tx *sqlx.Tx, id string) ([]string, error) {
+	rows, err := tx.QueryxContext(ctx, `UPDATE blobs SET refs = refs -
+			(SELECT COUNT(*) FROM paste_revisions r WHERE r.paste_id = $1 AND r.name = blobs.name)
+		WHERE name IN (SELECT name FROM paste_revisions WHERE paste_id = $1) RETURNING name, refs`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+
+	for rows.Next() {
+		var name string
+		var refs int
+
+		if err = rows.Scan(&name, &refs); err != nil {
+			return nil, err
+		}
+
+		if refs <= 0 {
+			unreferenced = append(unreferenced, name)
+		}
+	}
+
+	return unreferenced, rows.Err()
+}
+
+// UpdatePaste makes name the latest revision of the paste and returns its
+// number. Released pastes cannot be updated.
+func (d *DB) UpdatePaste(ctx context.Context, id string, name string, checksum string, size int64, place service.BlobFunc) (int, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var revision int
+
+	err = tx.QueryRowxContext(ctx, `UPDATE pastes SET name = $2, size = $3, checksum = $4, revision = revision + 1
+									WHERE id = $1 AND NOT released RETURNING revision`, id, name, size, nullString(checksum)).Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO paste_revisions (paste_id, revision, name, checksum) VALUES ($1, $2, $3, $4)`,
+		id, revision, name, nullString(checksum))
+	if err != nil {
+		return 0, err
+	}
+
+	if err = acquire(ctx, tx, name, 1, size, place); err != nil {
+		return 0, err
+	}
+
+	return revision, tx.Commit()
+}
+
+func (d *DB) GetRevision(ctx context.Context, id string, revision int) (string, string, error) {
+	var name string
+	var checksum sql.NullString
+
+	err := d.db.QueryRowxContext(ctx, `SELECT name, checksum FROM paste_revisions WHERE paste_id = $1 AND revision = $2`,
+		id, revision).Scan(&name, &checksum)
+
+	return name, checksum.String, err
 }
 
 func (d *DB) GetPaste(ctx context.Context, id string) (service.Paste, error) {
 	var paste Paste
 
-	err := d.db.QueryRowxContext(ctx, "SELECT id, name, expire_at, remaining_reads FROM pastes WHERE id = $1", id).StructScan(&paste)
+	err := d.db.QueryRowxContext(ctx, `SELECT `+pasteColumns+` FROM pastes WHERE id = $1`, id).StructScan(&paste)
 	if err != nil {
 		return service.Paste{}, err
 	}
 
+	return paste.toService(), nil
+}
+
+func (p Paste) toService() service.Paste {
 	var burnAfter int
 	var IsBurnable bool
 
-	if paste.RemainingReads.Valid {
-		burnAfter = int(paste.RemainingReads.Int64)
+	if p.RemainingReads.Valid {
+		burnAfter = int(p.RemainingReads.Int64)
 		IsBurnable = true
 	}
 
 	return service.Paste{
-		Name:       paste.Name,
-		Expire:     paste.ExpireAt,
-		BurnAfter:  burnAfter,
-		IsBurnable: IsBurnable,
-	}, nil
+		Name:        p.Name,
+		Expire:      p.ExpireAt,
+		BurnAfter:   burnAfter,
+		IsBurnable:  IsBurnable,
+		DeleteToken: p.DeleteToken.String,
+		Revision:    p.Revision,
+		Lang:        p.Lang.String,
+		Password:    p.Password.String,
+		Encrypted:   p.Encrypted,
+		Owner:       p.OwnerID.Int64,
+		Size:        p.Size.Int64,
+		CreatorIP:   p.CreatorIP.String,
+		ContentType: p.ContentType.String,
+		Filename:    p.Filename.String,
+		Modified:    p.ModifiedAt.Time,
+		Checksum:    p.Checksum.String,
+	}
 }
 
-func (d *DB) Readed(ctx context.Context, id string) error {
-	_, err := d.db.ExecContext(ctx, `UPDATE pastes SET remaining_reads = remaining_reads - 1 WHERE id = $1`, id)
-	return err
+// Consume expires the paste with its last read. Its references are kept
+// until the cleaner deletes it, the last reader still streams the content.
+func (d *DB) Consume(ctx context.Context, id string) (int, error) {
+	var remaining int
+
+	err := d.db.QueryRowxContext(ctx, `UPDATE pastes SET remaining_reads = remaining_reads - 1,
+										expire_at = CASE WHEN remaining_reads = 1 THEN $2 ELSE expire_at END
+									WHERE id = $1 AND remaining_reads > 0 AND NOT released RETURNING remaining_reads`, id, time.Now().UTC()).Scan(&remaining)
+
+	return remaining, err
 }
 
-func (d *DB) GetExpired(ctx context.Context) ([]string, error) {
-	r, err := d.db.QueryxContext(ctx,
-		`SELECT name FROM pastes GROUP BY name
-		HAVING COUNT(*) = SUM(CASE WHEN remaining_reads = 0 OR expire_at < NOW() THEN 1 ELSE 0 END)`,
-	)
+// DeletePaste removes the paste with all its revisions, dropping their
+// references unless the paste was released already.
+func (d *DB) DeletePaste(ctx context.Context, id string) ([]string, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	var pastes []string
+	var unreferenced []string
 
-	for r.Next() {
-		var v string
+	r, err := tx.ExecContext(ctx, `UPDATE pastes SET released = true WHERE id = $1 AND NOT released`, id)
+	if err != nil {
+		return nil, err
+	}
 
-		err = r.Scan(&v)
-		if err != nil {
+	if n, err := r.RowsAffected(); err == nil && n > 0 {
+		if unreferenced, err = release(ctx, tx, id); err != nil {
 			return nil, err
 		}
+	}
+
+	r, err = tx.ExecContext(ctx, `DELETE FROM pastes WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return unreferenced, tx.Commit()
+}
+
+// DeleteBlob holds the row of the unreferenced blob while remove runs, an
+// upload of the same content waits for it and places the content again.
+func (d *DB) DeleteBlob(ctx context.Context, name string, remove service.BlobFunc) error {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r, err := tx.ExecContext(ctx, `DELETE FROM blobs WHERE name = $1 AND refs = 0`, name)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err = remove(ctx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-		pastes = append(pastes, v)
+// DeleteExpired deletes up to limit pastes released or expired before the
+// given time with their revisions and returns how many of them had expired
+// and how many were burned by their last read. References of pastes that
+// were not released yet are dropped on the way.
+func (d *DB) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, int, error) {
+	var burned []bool
+
+	err := d.db.SelectContext(ctx, &burned, `WITH gone AS (
+			SELECT id, released FROM pastes WHERE released OR (NOT released AND expire_at < $2)
+			LIMIT $1 FOR UPDATE SKIP LOCKED
+		), refs AS (
+			SELECT r.name, COUNT(*) AS n FROM paste_revisions r JOIN gone g ON g.id = r.paste_id
+			WHERE NOT g.released GROUP BY r.name
+		), dropped AS (
+			UPDATE blobs SET refs = blobs.refs - refs.n FROM refs WHERE blobs.name = refs.name
+		)
+		DELETE FROM pastes p USING gone WHERE p.id = gone.id
+		RETURNING COALESCE(p.remaining_reads = 0, false)`, limit, before.UTC())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var n int
+
+	for _, b := range burned {
+		if b {
+			n++
+		}
+	}
+
+	return len(burned) - n, n, nil
+}
+
+// UnreferencedBlobs returns up to limit blobs no paste refers to, ordered by
+// name after the given one.
+func (d *DB) UnreferencedBlobs(ctx context.Context, after string, limit int) ([]string, error) {
+	var names []string
+
+	err := d.db.SelectContext(ctx, &names, `SELECT name FROM blobs WHERE refs = 0 AND name > $1
+		ORDER BY name LIMIT $2`, after, limit)
+
+	return names, err
+}
+
+func (d *DB) BlobStats(ctx context.Context) (service.BlobStats, error) {
+	var st service.BlobStats
+
+	err := d.db.QueryRowxContext(ctx, `SELECT COUNT(*), COALESCE(SUM(refs), 0), COALESCE(SUM(size), 0),
+		COALESCE(SUM((refs - 1) * size), 0) FROM blobs WHERE refs > 0`).Scan(&st.Blobs, &st.References, &st.Size, &st.Saved)
+
+	return st, err
+}
+
+func (d *DB) GetUserByKey(ctx context.Context, keyHash string) (service.User, error) {
+	var user service.User
+
+	err := d.db.QueryRowxContext(ctx, `SELECT u.id, u.name, u.admin FROM api_keys k JOIN users u ON u.id = k.user_id
+										WHERE k.hash = $1`, keyHash).Scan(&user.ID, &user.Name, &user.Admin)
+
+	return user, err
+}
+
+// CreateAPIKey stores the hash of a new key of the user, creating the user
+// if it does not exist yet. admin grants admin rights, it never revokes them.
+func (d *DB) CreateAPIKey(ctx context.Context, user string, keyHash string, admin bool) error {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+
+	err = tx.QueryRowxContext(ctx, `INSERT INTO users (name, admin) VALUES ($1, $2)
+									ON CONFLICT (name) DO UPDATE SET admin = users.admin OR excluded.admin RETURNING id`,
+		user, admin).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO api_keys (hash, user_id) VALUES ($1, $2)`, keyHash, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListPastes returns pastes of owner that are neither expired nor burned,
+// newest first.
+func (d *DB) ListPastes(ctx context.Context, owner int64, limit, offset int) ([]service.ListedPaste, error) {
+	var rows []ListedPaste
+
+	err := d.db.SelectContext(ctx, &rows, `SELECT `+pasteColumns+`, created_at
+		FROM pastes WHERE owner_id = $1 AND expire_at > NOW() AND (remaining_reads IS NULL OR remaining_reads > 0)
+		ORDER BY created_at DESC, id LIMIT $2 OFFSET $3`, owner, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return toListed(rows), nil
+}
+
+func toListed(rows []ListedPaste) []service.ListedPaste {
+	pastes := make([]service.ListedPaste, 0, len(rows))
+
+	for _, r := range rows {
+		pastes = append(pastes, service.ListedPaste{
+			Paste:   r.toService(),
+			ID:      r.ID,
+			Created: r.CreatedAt,
+		})
+	}
+
+	return pastes
+}
+
+// FindPastes returns pastes matching every set field of f, newest first.
+// A name matches pastes whose current or any older revision has it.
+func (d *DB) FindPastes(ctx context.Context, f service.PasteFilter) ([]service.ListedPaste, error) {
+	var where []string
+	var args []any
+
+	if f.ID != "" {
+		where = append(where, "id = ?")
+		args = append(args, f.ID)
+	}
+
+	if len(f.Names) > 0 {
+		in := "?" + strings.Repeat(", ?", len(f.Names)-1)
+		where = append(where, "(name IN ("+in+") OR id IN (SELECT paste_id FROM paste_revisions WHERE name IN ("+in+")))")
+
+		for _, name := range append(f.Names, f.Names...) {
+			args = append(args, name)
+		}
+	}
+
+	query := `SELECT ` + pasteColumns + `, created_at FROM pastes`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY created_at DESC, id LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	var rows []ListedPaste
+
+	if err := d.db.SelectContext(ctx, &rows, d.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	return toListed(rows), nil
+}
+
+func (d *DB) BlockHash(ctx context.Context, hash string, reason string) error {
+	_, err := d.db.ExecContext(ctx, `INSERT INTO blocked_hashes (hash, reason) VALUES ($1, $2)
+									ON CONFLICT (hash) DO UPDATE SET reason = excluded.reason`, hash, nullString(reason))
+	return err
+}
+
+func (d *DB) UnblockHash(ctx context.Context, hash string) error {
+	r, err := d.db.ExecContext(ctx, `DELETE FROM blocked_hashes WHERE hash = $1`, hash)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (d *DB) IsBlocked(ctx context.Context, hash string) (bool, error) {
+	var blocked bool
+
+	err := d.db.QueryRowxContext(ctx, `SELECT EXISTS (SELECT 1 FROM blocked_hashes WHERE hash = $1)`, hash).Scan(&blocked)
+
+	return blocked, err
+}
+
+// LegacyBlobs returns content names stored before checksums were recorded.
+func (d *DB) LegacyBlobs(ctx context.Context, after string, limit int) ([]string, error) {
+	var names []string
+
+	err := d.db.SelectContext(ctx, &names, `SELECT name FROM pastes WHERE checksum IS NULL AND name > $1
+		UNION SELECT name FROM paste_revisions WHERE checksum IS NULL AND name > $1
+		ORDER BY name LIMIT $2`, after, limit)
+
+	return names, err
+}
+
+func (d *DB) RenameBlob(ctx context.Context, old, name, checksum string, place service.BlobFunc) ([]string, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var refs int
+	var size sql.NullInt64
+
+	if old != name {
+		err = tx.QueryRowxContext(ctx, `SELECT refs, size FROM blobs WHERE name = $1 FOR UPDATE`, old).Scan(&refs, &size)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	var ids []string
+
+	err = tx.SelectContext(ctx, &ids, `UPDATE pastes SET name = $2, checksum = $3 WHERE name = $1 RETURNING id`,
+		old, name, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE paste_revisions SET name = $2, checksum = $3 WHERE name = $1`, old, name, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if old == name {
+		return ids, tx.Commit()
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE blobs SET refs = 0 WHERE name = $1`, old); err != nil {
+		return nil, err
+	}
+
+	if refs > 0 {
+		if err = acquire(ctx, tx, name, refs, size.Int64, place); err != nil {
+			return nil, err
+		}
 	}
 
-	return pastes, nil
+	return ids, tx.Commit()
 }
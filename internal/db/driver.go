@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/db/migrate"
+	"github.com/swmh/gopetbin/internal/db/sqlite"
+	"github.com/swmh/gopetbin/internal/service"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+type Config struct {
+	Driver string
+	Path   string
+	Addr   string
+	User   string
+	Pass   string
+	Name   string
+}
+
+// Repository is implemented by every driver.
+type Repository interface {
+	service.Repository
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (expired, burned int, err error)
+	UnreferencedBlobs(ctx context.Context, after string, limit int) ([]string, error)
+	Migrator() (*migrate.Migrator, error)
+	Ping(ctx context.Context) error
+	CreateAPIKey(ctx context.Context, user string, keyHash string, admin bool) error
+}
+
+// Open creates the repository selected by c.Driver, Postgres is used by default.
+func Open(c Config) (Repository, error) {
+	switch c.Driver {
+	case "", DriverPostgres:
+		return New(c.Addr, c.User, c.Pass, c.Name)
+	case DriverSQLite:
+		return sqlite.New(c.Path)
+	default:
+		return nil, fmt.Errorf("unknown db driver %q", c.Driver)
+	}
+}
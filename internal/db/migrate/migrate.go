@@ -0,0 +1,267 @@
+// Package migrate applies versioned SQL migrations. Migrations are files
+// named NNNN_name.up.sql and NNNN_name.down.sql, applied versions are
+// recorded in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrNoMigrations = errors.New("no migrations to roll back")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Locker serializes migrations between processes. It is called on the
+// connection all migrations are run on.
+type Locker interface {
+	Lock(ctx context.Context, conn *sqlx.Conn) error
+	Unlock(ctx context.Context, conn *sqlx.Conn) error
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	locker     Locker
+	migrations []Migration
+}
+
+// Load reads migrations from the root of fsys.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		name, direction, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("bad migration file name %q", file)
+		}
+
+		v, name, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %q", file)
+		}
+
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version %q: %w", file, err)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func New(db *sqlx.DB, fsys fs.FS, locker Locker) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load migrations: %w", err)
+	}
+
+	return &Migrator{
+		db:         db,
+		locker:     locker,
+		migrations: migrations,
+	}, nil
+}
+
+// withLock runs f on a single connection holding the migration lock.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sqlx.Conn) error) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = m.locker.Lock(ctx, conn); err != nil {
+		return fmt.Errorf("cannot acquire migration lock: %w", err)
+	}
+
+	defer m.locker.Unlock(context.WithoutCancel(ctx), conn)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("cannot create schema_migrations: %w", err)
+	}
+
+	return f(conn)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sqlx.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryxContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+
+	for rows.Next() {
+		var version int
+		var at time.Time
+
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) run(ctx context.Context, conn *sqlx.Conn, query, record string, args ...any) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, m.db.Rebind(record), args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Up applies all pending migrations and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+
+			err = m.run(ctx, conn, mg.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				mg.Version, mg.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("cannot apply migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+
+			done = append(done, mg)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the latest applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var done Migration
+
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+
+			err = m.run(ctx, conn, mg.Down, `DELETE FROM schema_migrations WHERE version = ?`, mg.Version)
+			if err != nil {
+				return fmt.Errorf("cannot roll back migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+
+			done = mg
+
+			return nil
+		}
+
+		return ErrNoMigrations
+	})
+
+	return done, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var status []Status
+
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			at, ok := applied[mg.Version]
+			status = append(status, Status{
+				Migration: mg,
+				Applied:   ok,
+				AppliedAt: at,
+			})
+		}
+
+		return nil
+	})
+
+	return status, err
+}
@@ -0,0 +1,157 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+
+	"github.com/swmh/gopetbin/internal/db/migrate"
+)
+
+type noLock struct{}
+
+func (noLock) Lock(context.Context, *sqlx.Conn) error   { return nil }
+func (noLock) Unlock(context.Context, *sqlx.Conn) error { return nil }
+
+var migrations = fstest.MapFS{
+	"0002_notes.up.sql":   {Data: []byte(`ALTER TABLE items ADD COLUMN note text`)},
+	"0002_notes.down.sql": {Data: []byte(`ALTER TABLE items DROP COLUMN note`)},
+	"0001_init.up.sql":    {Data: []byte(`CREATE TABLE items (id integer PRIMARY KEY)`)},
+	"0001_init.down.sql":  {Data: []byte(`DROP TABLE items`)},
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []string
+		wantErr bool
+	}{
+		{name: "ordered by version", fsys: migrations, want: []string{"init", "notes"}},
+		{name: "empty", fsys: fstest.MapFS{}},
+		{name: "no direction", fsys: fstest.MapFS{"0001_init.sql": {}}, wantErr: true},
+		{name: "bad direction", fsys: fstest.MapFS{"0001_init.sideways.sql": {}}, wantErr: true},
+		{name: "no name", fsys: fstest.MapFS{"0001.up.sql": {}}, wantErr: true},
+		{name: "bad version", fsys: fstest.MapFS{"first_init.up.sql": {}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := migrate.Load(tt.fsys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want error %v", err, tt.wantErr)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("loaded %d migrations, want %d", len(got), len(tt.want))
+			}
+
+			for i, m := range got {
+				if m.Name != tt.want[i] || m.Version != i+1 || m.Up == "" || m.Down == "" {
+					t.Errorf("migration %d is %+v, want %s", i, m, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlx.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	m, err := migrate.New(db, migrations, noLock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if done, err := m.Up(ctx); err != nil || len(done) != 2 {
+		t.Fatalf("up applied %d migrations, %v, want 2", len(done), err)
+	}
+
+	if done, err := m.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second up applied %d migrations, %v, want none", len(done), err)
+	}
+
+	if _, err = db.Exec(`INSERT INTO items (id, note) VALUES (1, 'a')`); err != nil {
+		t.Fatalf("cannot use the migrated schema: %v", err)
+	}
+
+	mg, err := m.Down(ctx)
+	if err != nil || mg.Name != "notes" {
+		t.Fatalf("down rolled back %q, %v, want notes", mg.Name, err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status) != 2 || !status[0].Applied || status[1].Applied {
+		t.Errorf("status is %+v, want init applied only", status)
+	}
+
+	if _, err = m.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Down(ctx); !errors.Is(err, migrate.ErrNoMigrations) {
+		t.Fatalf("down with nothing applied is %v, want %v", err, migrate.ErrNoMigrations)
+	}
+
+	if done, err := m.Up(ctx); err != nil || len(done) != 2 {
+		t.Fatalf("up after down applied %d migrations, %v, want 2", len(done), err)
+	}
+}
+
+// A failing migration is not recorded and stops the ones after it.
+func TestUpFails(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlx.Open("sqlite", "file::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	fsys := fstest.MapFS{
+		"0001_init.up.sql":    migrations["0001_init.up.sql"],
+		"0001_init.down.sql":  migrations["0001_init.down.sql"],
+		"0002_bad.up.sql":     {Data: []byte(`ALTER TABLE missing ADD COLUMN note text`)},
+		"0002_bad.down.sql":   {Data: []byte(`SELECT 1`)},
+		"0003_notes.up.sql":   migrations["0002_notes.up.sql"],
+		"0003_notes.down.sql": migrations["0002_notes.down.sql"],
+	}
+
+	m, err := migrate.New(db, fsys, noLock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Up(ctx); err == nil {
+		t.Fatal("up of a bad migration succeeded")
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false, false} {
+		if status[i].Applied != want {
+			t.Errorf("migration %d applied is %v, want %v", status[i].Version, status[i].Applied, want)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-CREATE TABLE "pastes" (
+CREATE TABLE IF NOT EXISTS "pastes" (
 	"id" text PRIMARY KEY,
 	"name" text NOT NULL,
 	"expire_at" timestamp NOT NULL,
@@ -0,0 +1,10 @@
+DROP TABLE "paste_revisions";
+DROP TABLE "orphans";
+DROP INDEX "pastes_name_idx";
+
+ALTER TABLE "pastes"
+	DROP COLUMN "delete_token",
+	DROP COLUMN "revision",
+	DROP COLUMN "lang",
+	DROP COLUMN "password",
+	DROP COLUMN "encrypted";
@@ -0,0 +1,27 @@
+ALTER TABLE "pastes"
+	ADD COLUMN IF NOT EXISTS "delete_token" text,
+	ADD COLUMN IF NOT EXISTS "revision" int NOT NULL DEFAULT 1,
+	ADD COLUMN IF NOT EXISTS "lang" text,
+	ADD COLUMN IF NOT EXISTS "password" text,
+	ADD COLUMN IF NOT EXISTS "encrypted" boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS "pastes_name_idx" ON "pastes" ("name");
+
+CREATE TABLE IF NOT EXISTS "orphans" (
+	"name" text PRIMARY KEY,
+	"created_at" timestamp NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE TABLE IF NOT EXISTS "paste_revisions" (
+	"paste_id" text NOT NULL REFERENCES "pastes" ("id") ON DELETE CASCADE,
+	"revision" int NOT NULL,
+	"name" text NOT NULL,
+	"created_at" timestamp NULL DEFAULT (now() AT TIME ZONE 'utc'::text),
+	PRIMARY KEY ("paste_id", "revision")
+);
+
+CREATE INDEX IF NOT EXISTS "paste_revisions_name_idx" ON "paste_revisions" ("name");
+
+INSERT INTO "paste_revisions" ("paste_id", "revision", "name")
+	SELECT "id", "revision", "name" FROM "pastes"
+	ON CONFLICT DO NOTHING;
@@ -0,0 +1,15 @@
+CREATE TABLE "users" (
+	"id" bigserial PRIMARY KEY,
+	"name" text NOT NULL UNIQUE,
+	"created_at" timestamp NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE TABLE "api_keys" (
+	"hash" text PRIMARY KEY,
+	"user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+	"created_at" timestamp NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+ALTER TABLE "pastes" ADD COLUMN "owner_id" bigint REFERENCES "users" ("id") ON DELETE SET NULL;
+
+CREATE INDEX "pastes_owner_idx" ON "pastes" ("owner_id", "created_at");
@@ -0,0 +1,13 @@
+ALTER TABLE "users" ADD COLUMN "admin" boolean NOT NULL DEFAULT false;
+
+ALTER TABLE "pastes"
+	ADD COLUMN "size" bigint,
+	ADD COLUMN "creator_ip" text;
+
+CREATE INDEX "pastes_created_at_idx" ON "pastes" ("created_at");
+
+CREATE TABLE "blocked_hashes" (
+	"hash" text PRIMARY KEY,
+	"reason" text,
+	"created_at" timestamp NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE "pastes"
+	DROP COLUMN "content_type",
+	DROP COLUMN "filename";
@@ -0,0 +1,3 @@
+ALTER TABLE "pastes"
+	ADD COLUMN "content_type" text,
+	ADD COLUMN "filename" text;
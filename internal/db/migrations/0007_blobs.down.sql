@@ -0,0 +1,13 @@
+CREATE TABLE "orphans" (
+	"name" text PRIMARY KEY,
+	"created_at" timestamp NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+INSERT INTO "orphans" ("name")
+	SELECT "name" FROM "blobs" WHERE "refs" = 0;
+
+DROP TABLE "blobs";
+
+DROP INDEX "pastes_unreleased_idx";
+
+ALTER TABLE "pastes" DROP COLUMN "released";
@@ -0,0 +1 @@
+DROP INDEX "pastes_released_idx";
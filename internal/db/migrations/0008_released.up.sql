@@ -0,0 +1 @@
+CREATE INDEX "pastes_released_idx" ON "pastes" ("id") WHERE "released";
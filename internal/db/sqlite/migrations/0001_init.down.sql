@@ -0,0 +1,3 @@
+DROP TABLE orphans;
+DROP TABLE paste_revisions;
+DROP TABLE pastes;
@@ -0,0 +1,29 @@
+CREATE TABLE pastes (
+	id text PRIMARY KEY,
+	name text NOT NULL,
+	expire_at integer NOT NULL,
+	remaining_reads integer,
+	delete_token text,
+	revision integer NOT NULL DEFAULT 1,
+	lang text,
+	password text,
+	encrypted boolean NOT NULL DEFAULT false,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
+
+CREATE INDEX pastes_name_idx ON pastes (name);
+
+CREATE TABLE paste_revisions (
+	paste_id text NOT NULL REFERENCES pastes (id) ON DELETE CASCADE,
+	revision integer NOT NULL,
+	name text NOT NULL,
+	created_at integer NOT NULL DEFAULT (unixepoch()),
+	PRIMARY KEY (paste_id, revision)
+);
+
+CREATE INDEX paste_revisions_name_idx ON paste_revisions (name);
+
+CREATE TABLE orphans (
+	name text PRIMARY KEY,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
@@ -0,0 +1,6 @@
+DROP INDEX pastes_owner_idx;
+
+ALTER TABLE pastes DROP COLUMN owner_id;
+
+DROP TABLE api_keys;
+DROP TABLE users;
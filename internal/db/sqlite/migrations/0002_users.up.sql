@@ -0,0 +1,15 @@
+CREATE TABLE users (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	name text NOT NULL UNIQUE,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
+
+CREATE TABLE api_keys (
+	hash text PRIMARY KEY,
+	user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
+
+ALTER TABLE pastes ADD COLUMN owner_id integer REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX pastes_owner_idx ON pastes (owner_id, created_at);
@@ -0,0 +1,8 @@
+DROP TABLE blocked_hashes;
+
+DROP INDEX pastes_created_at_idx;
+
+ALTER TABLE pastes DROP COLUMN size;
+ALTER TABLE pastes DROP COLUMN creator_ip;
+
+ALTER TABLE users DROP COLUMN admin;
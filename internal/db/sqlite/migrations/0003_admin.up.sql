@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN admin boolean NOT NULL DEFAULT false;
+
+ALTER TABLE pastes ADD COLUMN size integer;
+ALTER TABLE pastes ADD COLUMN creator_ip text;
+
+CREATE INDEX pastes_created_at_idx ON pastes (created_at);
+
+CREATE TABLE blocked_hashes (
+	hash text PRIMARY KEY,
+	reason text,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
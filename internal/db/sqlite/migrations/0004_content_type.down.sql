@@ -0,0 +1,2 @@
+ALTER TABLE pastes DROP COLUMN content_type;
+ALTER TABLE pastes DROP COLUMN filename;
@@ -0,0 +1,2 @@
+ALTER TABLE paste_revisions DROP COLUMN checksum;
+ALTER TABLE pastes DROP COLUMN checksum;
@@ -0,0 +1,2 @@
+ALTER TABLE pastes ADD COLUMN checksum text;
+ALTER TABLE paste_revisions ADD COLUMN checksum text;
@@ -0,0 +1,13 @@
+CREATE TABLE orphans (
+	name text PRIMARY KEY,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
+
+INSERT INTO orphans (name)
+	SELECT name FROM blobs WHERE refs = 0;
+
+DROP TABLE blobs;
+
+DROP INDEX pastes_unreleased_idx;
+
+ALTER TABLE pastes DROP COLUMN released;
@@ -0,0 +1,24 @@
+ALTER TABLE pastes ADD COLUMN released boolean NOT NULL DEFAULT false;
+
+UPDATE pastes SET released = true WHERE remaining_reads = 0 OR expire_at < unixepoch();
+
+CREATE INDEX pastes_unreleased_idx ON pastes (expire_at) WHERE NOT released;
+
+CREATE TABLE blobs (
+	name text PRIMARY KEY,
+	refs integer NOT NULL DEFAULT 0,
+	size integer,
+	created_at integer NOT NULL DEFAULT (unixepoch())
+);
+
+CREATE INDEX blobs_unreferenced_idx ON blobs (name) WHERE refs = 0;
+
+INSERT INTO blobs (name, refs, size)
+	SELECT r.name, SUM(CASE WHEN p.released THEN 0 ELSE 1 END), MAX(CASE WHEN p.name = r.name THEN p.size END)
+	FROM paste_revisions r JOIN pastes p ON p.id = r.paste_id
+	GROUP BY r.name;
+
+INSERT OR IGNORE INTO blobs (name, refs)
+	SELECT name, 0 FROM orphans;
+
+DROP TABLE orphans;
@@ -0,0 +1 @@
+ALTER TABLE blobs ADD COLUMN released boolean NOT NULL DEFAULT false;
@@ -0,0 +1,660 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/swmh/gopetbin/internal/db/migrate"
+	"github.com/swmh/gopetbin/internal/service"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// DB is a service.Repository backed by an embedded SQLite database.
+// Timestamps are stored as unix seconds.
+type DB struct {
+	db *sqlx.DB
+}
+
+type Paste struct {
+	ID             string         `db:"id"`
+	Name           string         `db:"name"`
+	ExpireAt       int64          `db:"expire_at"`
+	RemainingReads sql.NullInt64  `db:"remaining_reads"`
+	DeleteToken    sql.NullString `db:"delete_token"`
+	Revision       int            `db:"revision"`
+	Lang           sql.NullString `db:"lang"`
+	Password       sql.NullString `db:"password"`
+	Encrypted      bool           `db:"encrypted"`
+	OwnerID        sql.NullInt64  `db:"owner_id"`
+	Size           sql.NullInt64  `db:"size"`
+	CreatorIP      sql.NullString `db:"creator_ip"`
+	ContentType    sql.NullString `db:"content_type"`
+	Filename       sql.NullString `db:"filename"`
+	ModifiedAt     int64          `db:"modified_at"`
+	Checksum       sql.NullString `db:"checksum"`
+}
+
+const pasteColumns = `id, name, expire_at, remaining_reads, delete_token, revision, lang, password, encrypted,
+	owner_id, size, creator_ip, content_type, filename, checksum,
+	COALESCE((SELECT r.created_at FROM paste_revisions r WHERE r.paste_id = pastes.id AND r.revision = pastes.revision),
+		pastes.created_at) AS modified_at`
+
+type ListedPaste struct {
+	Paste
+	CreatedAt int64 `db:"created_at"`
+}
+
+// Memory is the path of a database kept in memory, for tests and local
+// runs. It is gone once the DB is closed.
+const Memory = ":memory:"
+
+func New(path string) (*DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)", path)
+
+	db, err := sqlx.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open database: %w", err)
+	}
+
+	// Every connection to memory opens a database of its own, so a single
+	// one is kept for good.
+	if path == Memory {
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("cannot open database: %w", err)
+	}
+
+	return &DB{db}, nil
+}
+
+// noLock is used for migrations since SQLite already serializes writers
+// and is only used by a single node.
+type noLock struct{}
+
+func (noLock) Lock(context.Context, *sqlx.Conn) error   { return nil }
+func (noLock) Unlock(context.Context, *sqlx.Conn) error { return nil }
+
+func (d *DB) Migrator() (*migrate.Migrator, error) {
+	sub, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(d.db, sub, noLock{})
+}
+
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt64(i int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: i, Valid: i != 0}
+}
+
+func (d *DB) IsNoSuchPaste(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+func (d *DB) CreatePaste(ctx context.Context, id string, paste service.Paste, place service.BlobFunc) error {
+	return waitReleased(ctx, func() error { return d.createPaste(ctx, id, paste, place) })
+}
+
+func (d *DB) createPaste(ctx context.Context, id string, paste service.Paste, place service.BlobFunc) error {
+	var remainingReads sql.NullInt64
+	if paste.IsBurnable {
+		remainingReads = sql.NullInt64{
+			Int64: int64(paste.BurnAfter),
+			Valid: true,
+		}
+	}
+
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO pastes (id, name, expire_at, remaining_reads, delete_token, lang, password, encrypted,
+									owner_id, size, creator_ip, content_type, filename, checksum) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, paste.Name, paste.Expire.Unix(), remainingReads, paste.DeleteToken, nullString(paste.Lang),
+		nullString(paste.Password), paste.Encrypted, nullInt64(paste.Owner), paste.Size, nullString(paste.CreatorIP),
+		nullString(paste.ContentType), nullString(paste.Filename), nullString(paste.Checksum))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO paste_revisions (paste_id, revision, name, checksum) VALUES (?, 1, ?, ?)`,
+		id, paste.Name, nullString(paste.Checksum))
+	if err != nil {
+		return err
+	}
+
+	if err = acquire(ctx, tx, paste.Name, 1, paste.Size, place); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// errReleased is returned by acquire for a blob the cleaner is deleting.
+var errReleased = errors.New("blob is being deleted")
+
+const (
+	releaseWait     = 100 * time.Millisecond
+	releaseAttempts = 50
+)
+
+// waitReleased runs f again while the blob it acquires is being deleted,
+// the cleaner drops the row once the content is removed.
+func waitReleased(ctx context.Context, f func() error) error {
+	for i := 1; ; i++ {
+		err := f()
+		if !errors.Is(err, errReleased) || i == releaseAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(releaseWait):
+		}
+	}
+}
+
+// acquire takes refs references to the blob and calls place if it was not
+// referenced before. The write transaction keeps the cleaner out while
+// the blob is placed, a blob it released already is not taken.
+func acquire(ctx context.Context, tx *sqlx.Tx, name string, refs int, size int64, place service.BlobFunc) error {
+	var total int
+
+	err := tx.QueryRowxContext(ctx, `INSERT INTO blobs (name, refs, size) VALUES (?1, ?2, ?3)
+									ON CONFLICT (name) DO UPDATE SET refs = blobs.refs + excluded.refs,
+										size = COALESCE(blobs.size, excluded.size)
+									WHERE NOT blobs.released
+									RETURNING refs`, name, refs, nullInt64(size)).Scan(&total)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errReleased
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if total > refs {
+		return nil
+	}
+
+	return place(ctx)
+}
+
+// release drops the references of every revision of the paste and returns
+// the blobs no paste refers to anymore.
+func release(ctx context.Context, tx *sqlx.Tx, id string) ([]string, error) {
+	rows, err := tx.QueryxContext(ctx, `UPDATE blobs SET refs = refs -
+			(SELECT COUNT(*) FROM paste_revisions r WHERE r.paste_id = ?1 AND r.name = blobs.name)
+		WHERE name IN (SELECT name FROM paste_revisions WHERE paste_id = ?1) RETURNING name, refs`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+
+	for rows.Next() {
+		var name string
+		var refs int
+
+		if err = rows.Scan(&name, &refs); err != nil {
+			return nil, err
+		}
+
+		if refs <= 0 {
+			unreferenced = append(unreferenced, name)
+		}
+	}
+
+	return unreferenced, rows.Err()
+}
+
+func (d *DB) UpdatePaste(ctx context.Context, id string, name string, checksum string, size int64, place service.BlobFunc) (int, error) {
+	var revision int
+
+	err := waitReleased(ctx, func() (err error) {
+		revision, err = d.updatePaste(ctx, id, name, checksum, size, place)
+		return err
+	})
+
+	return revision, err
+}
+
+func (d *DB) updatePaste(ctx context.Context, id string, name string, checksum string, size int64, place service.BlobFunc) (int, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var revision int
+
+	err = tx.QueryRowxContext(ctx, `UPDATE pastes SET name = ?, size = ?, checksum = ?, revision = revision + 1
+									WHERE id = ? AND NOT released RETURNING revision`, name, size, nullString(checksum), id).Scan(&revision)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO paste_revisions (paste_id, revision, name, checksum) VALUES (?, ?, ?, ?)`,
+		id, revision, name, nullString(checksum))
+	if err != nil {
+		return 0, err
+	}
+
+	if err = acquire(ctx, tx, name, 1, size, place); err != nil {
+		return 0, err
+	}
+
+	return revision, tx.Commit()
+}
+
+func (d *DB) GetRevision(ctx context.Context, id string, revision int) (string, string, error) {
+	var name string
+	var checksum sql.NullString
+
+	err := d.db.QueryRowxContext(ctx, `SELECT name, checksum FROM paste_revisions WHERE paste_id = ? AND revision = ?`,
+		id, revision).Scan(&name, &checksum)
+
+	return name, checksum.String, err
+}
+
+func (d *DB) GetPaste(ctx context.Context, id string) (service.Paste, error) {
+	var paste Paste
+
+	err := d.db.QueryRowxContext(ctx, `SELECT `+pasteColumns+` FROM pastes WHERE id = ?`, id).StructScan(&paste)
+	if err != nil {
+		return service.Paste{}, err
+	}
+
+	return paste.toService(), nil
+}
+
+func (p Paste) toService() service.Paste {
+	var burnAfter int
+	var IsBurnable bool
+
+	if p.RemainingReads.Valid {
+		burnAfter = int(p.RemainingReads.Int64)
+		IsBurnable = true
+	}
+
+	return service.Paste{
+		Name:        p.Name,
+		Expire:      time.Unix(p.ExpireAt, 0).UTC(),
+		BurnAfter:   burnAfter,
+		IsBurnable:  IsBurnable,
+		DeleteToken: p.DeleteToken.String,
+		Revision:    p.Revision,
+		Lang:        p.Lang.String,
+		Password:    p.Password.String,
+		Encrypted:   p.Encrypted,
+		Owner:       p.OwnerID.Int64,
+		Size:        p.Size.Int64,
+		CreatorIP:   p.CreatorIP.String,
+		ContentType: p.ContentType.String,
+		Filename:    p.Filename.String,
+		Modified:    time.Unix(p.ModifiedAt, 0).UTC(),
+		Checksum:    p.Checksum.String,
+	}
+}
+
+// Consume expires the paste with its last read. Its references are kept
+// until the cleaner deletes it, the last reader still streams the content.
+func (d *DB) Consume(ctx context.Context, id string) (int, error) {
+	var remaining int
+
+	err := d.db.QueryRowxContext(ctx, `UPDATE pastes SET remaining_reads = remaining_reads - 1,
+										expire_at = CASE WHEN remaining_reads = 1 THEN ?2 ELSE expire_at END
+									WHERE id = ?1 AND remaining_reads > 0 AND NOT released RETURNING remaining_reads`, id, time.Now().Unix()).Scan(&remaining)
+
+	return remaining, err
+}
+
+// DeletePaste removes the paste with all its revisions, dropping their
+// references unless the paste was released already.
+func (d *DB) DeletePaste(ctx context.Context, id string) ([]string, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var unreferenced []string
+
+	r, err := tx.ExecContext(ctx, `UPDATE pastes SET released = true WHERE id = ? AND NOT released`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n > 0 {
+		if unreferenced, err = release(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err = tx.ExecContext(ctx, `DELETE FROM pastes WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return unreferenced, tx.Commit()
+}
+
+// DeleteBlob releases the unreferenced blob in a transaction of its own,
+// so writers are not locked out while remove runs, and forgets it after.
+// Uploads of the same content wait for the row to go and place the
+// content again. A failed remove leaves the blob to the next attempt.
+func (d *DB) DeleteBlob(ctx context.Context, name string, remove service.BlobFunc) error {
+	r, err := d.db.ExecContext(ctx, `UPDATE blobs SET released = true WHERE name = ? AND refs = 0`, name)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err = remove(ctx); err != nil {
+		if _, uerr := d.db.ExecContext(ctx, `UPDATE blobs SET released = false WHERE name = ?`, name); uerr != nil {
+			return errors.Join(err, uerr)
+		}
+
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, `DELETE FROM blobs WHERE name = ? AND released`, name)
+
+	return err
+}
+
+// DeleteExpired deletes up to limit pastes released or expired before the
+// given time with their revisions and returns how many of them had expired
+// and how many were burned by their last read. References of pastes that
+// were not released yet are dropped on the way.
+func (d *DB) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, int, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var expired []string
+
+	err = tx.SelectContext(ctx, &expired, `UPDATE pastes SET released = true WHERE id IN
+		(SELECT id FROM pastes WHERE NOT released AND expire_at < ?1 LIMIT ?2) RETURNING id`, before.Unix(), limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, id := range expired {
+		if _, err = release(ctx, tx, id); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var burned []bool
+
+	err = tx.SelectContext(ctx, &burned, `DELETE FROM pastes WHERE id IN (SELECT id FROM pastes WHERE released LIMIT ?)
+		RETURNING COALESCE(remaining_reads = 0, false)`, limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var n int
+
+	for _, b := range burned {
+		if b {
+			n++
+		}
+	}
+
+	return len(burned) - n, n, tx.Commit()
+}
+
+// UnreferencedBlobs returns up to limit blobs no paste refers to, ordered by
+// name after the given one.
+func (d *DB) UnreferencedBlobs(ctx context.Context, after string, limit int) ([]string, error) {
+	var names []string
+
+	err := d.db.SelectContext(ctx, &names, `SELECT name FROM blobs WHERE refs = 0 AND name > ?
+		ORDER BY name LIMIT ?`, after, limit)
+
+	return names, err
+}
+
+func (d *DB) BlobStats(ctx context.Context) (service.BlobStats, error) {
+	var st service.BlobStats
+
+	err := d.db.QueryRowxContext(ctx, `SELECT COUNT(*), COALESCE(SUM(refs), 0), COALESCE(SUM(size), 0),
+		COALESCE(SUM((refs - 1) * size), 0) FROM blobs WHERE refs > 0`).Scan(&st.Blobs, &st.References, &st.Size, &st.Saved)
+
+	return st, err
+}
+
+func (d *DB) GetUserByKey(ctx context.Context, keyHash string) (service.User, error) {
+	var user service.User
+
+	err := d.db.QueryRowxContext(ctx, `SELECT u.id, u.name, u.admin FROM api_keys k JOIN users u ON u.id = k.user_id
+										WHERE k.hash = ?`, keyHash).Scan(&user.ID, &user.Name, &user.Admin)
+
+	return user, err
+}
+
+// CreateAPIKey stores the hash of a new key of the user, creating the user
+// if it does not exist yet. admin grants admin rights, it never revokes them.
+func (d *DB) CreateAPIKey(ctx context.Context, user string, keyHash string, admin bool) error {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+
+	err = tx.QueryRowxContext(ctx, `INSERT INTO users (name, admin) VALUES (?, ?)
+									ON CONFLICT (name) DO UPDATE SET admin = users.admin OR excluded.admin RETURNING id`,
+		user, admin).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO api_keys (hash, user_id) VALUES (?, ?)`, keyHash, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListPastes returns pastes of owner that are neither expired nor burned,
+// newest first.
+func (d *DB) ListPastes(ctx context.Context, owner int64, limit, offset int) ([]service.ListedPaste, error) {
+	var rows []ListedPaste
+
+	err := d.db.SelectContext(ctx, &rows, `SELECT `+pasteColumns+`, created_at
+		FROM pastes WHERE owner_id = ? AND expire_at > ? AND (remaining_reads IS NULL OR remaining_reads > 0)
+		ORDER BY created_at DESC, id LIMIT ? OFFSET ?`, owner, time.Now().Unix(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return toListed(rows), nil
+}
+
+func toListed(rows []ListedPaste) []service.ListedPaste {
+	pastes := make([]service.ListedPaste, 0, len(rows))
+
+	for _, r := range rows {
+		pastes = append(pastes, service.ListedPaste{
+			Paste:   r.toService(),
+			ID:      r.ID,
+			Created: time.Unix(r.CreatedAt, 0).UTC(),
+		})
+	}
+
+	return pastes
+}
+
+// FindPastes returns pastes matching every set field of f, newest first.
+// A name matches pastes whose current or any older revision has it.
+func (d *DB) FindPastes(ctx context.Context, f service.PasteFilter) ([]service.ListedPaste, error) {
+	var where []string
+	var args []any
+
+	if f.ID != "" {
+		where = append(where, "id = ?")
+		args = append(args, f.ID)
+	}
+
+	if len(f.Names) > 0 {
+		in := "?" + strings.Repeat(", ?", len(f.Names)-1)
+		where = append(where, "(name IN ("+in+") OR id IN (SELECT paste_id FROM paste_revisions WHERE name IN ("+in+")))")
+
+		for _, name := range append(f.Names, f.Names...) {
+			args = append(args, name)
+		}
+	}
+
+	query := `SELECT ` + pasteColumns + `, created_at FROM pastes`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY created_at DESC, id LIMIT ? OFFSET ?"
+	args = append(args, f.Limit, f.Offset)
+
+	var rows []ListedPaste
+
+	if err := d.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, err
+	}
+
+	return toListed(rows), nil
+}
+
+func (d *DB) BlockHash(ctx context.Context, hash string, reason string) error {
+	_, err := d.db.ExecContext(ctx, `INSERT INTO blocked_hashes (hash, reason) VALUES (?, ?)
+									ON CONFLICT (hash) DO UPDATE SET reason = excluded.reason`, hash, nullString(reason))
+	return err
+}
+
+func (d *DB) UnblockHash(ctx context.Context, hash string) error {
+	r, err := d.db.ExecContext(ctx, `DELETE FROM blocked_hashes WHERE hash = ?`, hash)
+	if err != nil {
+		return err
+	}
+
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (d *DB) IsBlocked(ctx context.Context, hash string) (bool, error) {
+	var blocked bool
+
+	err := d.db.QueryRowxContext(ctx, `SELECT EXISTS (SELECT 1 FROM blocked_hashes WHERE hash = ?)`, hash).Scan(&blocked)
+
+	return blocked, err
+}
+
+// LegacyBlobs returns content names stored before checksums were recorded.
+func (d *DB) LegacyBlobs(ctx context.Context, after string, limit int) ([]string, error) {
+	var names []string
+
+	err := d.db.SelectContext(ctx, &names, `SELECT name FROM pastes WHERE checksum IS NULL AND name > ?
+		UNION SELECT name FROM paste_revisions WHERE checksum IS NULL AND name > ?
+		ORDER BY name LIMIT ?`, after, after, limit)
+
+	return names, err
+}
+
+func (d *DB) RenameBlob(ctx context.Context, old, name, checksum string, place service.BlobFunc) ([]string, error) {
+	var ids []string
+
+	err := waitReleased(ctx, func() (err error) {
+		ids, err = d.renameBlob(ctx, old, name, checksum, place)
+		return err
+	})
+
+	return ids, err
+}
+
+func (d *DB) renameBlob(ctx context.Context, old, name, checksum string, place service.BlobFunc) ([]string, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var refs int
+	var size sql.NullInt64
+
+	if old != name {
+		err = tx.QueryRowxContext(ctx, `SELECT refs, size FROM blobs WHERE name = ?1`, old).Scan(&refs, &size)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	var ids []string
+
+	err = tx.SelectContext(ctx, &ids, `UPDATE pastes SET name = ?2, checksum = ?3 WHERE name = ?1 RETURNING id`,
+		old, name, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE paste_revisions SET name = ?2, checksum = ?3 WHERE name = ?1`, old, name, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if old == name {
+		return ids, tx.Commit()
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE blobs SET refs = 0 WHERE name = ?1`, old); err != nil {
+		return nil, err
+	}
+
+	if refs > 0 {
+		if err = acquire(ctx, tx, name, refs, size.Int64, place); err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
@@ -0,0 +1,328 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/db/migrate"
+	"github.com/swmh/gopetbin/internal/db/sqlite"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+)
+
+func nop(context.Context) error { return nil }
+
+// Every migration of the schema rolls back and applies again.
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlite.New(sqlite.Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := db.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		mg, err := m.Down(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mg.Version != all[i].Version {
+			t.Errorf("rolled back %d, want %d", mg.Version, all[i].Version)
+		}
+	}
+
+	if _, err = m.Down(ctx); !errors.Is(err, migrate.ErrNoMigrations) {
+		t.Fatalf("down with nothing applied is %v, want %v", err, migrate.ErrNoMigrations)
+	}
+
+	if again, err := m.Up(ctx); err != nil || len(again) != len(all) {
+		t.Fatalf("up after down applied %d migrations, %v, want %d", len(again), err, len(all))
+	}
+
+	if err = db.CreatePaste(ctx, "4Gp3gCWeXl", service.Paste{Name: "a", Expire: time.Now().Add(time.Hour)}, nop); err != nil {
+		t.Fatalf("cannot create paste in the migrated schema: %v", err)
+	}
+}
+
+func TestCreateGet(t *testing.T) {
+	ctx := context.Background()
+	db := servicetest.NewRepo(t)
+
+	want := service.Paste{
+		Name:        "blob",
+		Expire:      time.Now().Add(time.Hour).Truncate(time.Second).UTC(),
+		BurnAfter:   2,
+		IsBurnable:  true,
+		DeleteToken: "token",
+		Lang:        "go",
+		Password:    "hash",
+		Encrypted:   true,
+		Size:        4,
+		CreatorIP:   "203.0.113.7",
+		ContentType: "text/plain",
+		Filename:    "main.go",
+		Checksum:    "abc",
+	}
+
+	var placed int
+
+	place := func(context.Context) error {
+		placed++
+		return nil
+	}
+
+	if err := db.CreatePaste(ctx, "4Gp3gCWeXl", want, place); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same content is placed once and referenced twice.
+	if err := db.CreatePaste(ctx, "7ZlwE4ADZe", service.Paste{Name: "blob", Expire: want.Expire, Size: 4}, place); err != nil {
+		t.Fatal(err)
+	}
+
+	if placed != 1 {
+		t.Errorf("placed %d times, want 1", placed)
+	}
+
+	got, err := db.GetPaste(ctx, "4Gp3gCWeXl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want.Revision = 1
+	want.Modified = got.Modified
+
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err = db.GetPaste(ctx, "missing"); !db.IsNoSuchPaste(err) {
+		t.Errorf("missing paste is %v, want no such paste", err)
+	}
+
+	st, err := db.BlobStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (service.BlobStats{Blobs: 1, References: 2, Size: 4, Saved: 4}); st != want {
+		t.Errorf("stats are %+v, want %+v", st, want)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	ctx := context.Background()
+	db := servicetest.NewRepo(t)
+
+	p := service.Paste{Name: "a", Expire: time.Now().Add(time.Hour), BurnAfter: 2, IsBurnable: true}
+	if err := db.CreatePaste(ctx, "4Gp3gCWeXl", p, nop); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []int{1, 0} {
+		left, err := db.Consume(ctx, "4Gp3gCWeXl")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if left != want {
+			t.Errorf("%d reads left, want %d", left, want)
+		}
+	}
+
+	if _, err := db.Consume(ctx, "4Gp3gCWeXl"); !db.IsNoSuchPaste(err) {
+		t.Errorf("read of a burned paste is %v, want no such paste", err)
+	}
+
+	// The last read expires the paste.
+	got, err := db.GetPaste(ctx, "4Gp3gCWeXl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Expire.After(time.Now()) {
+		t.Errorf("burned paste expires at %s", got.Expire)
+	}
+
+	if err = db.CreatePaste(ctx, "7ZlwE4ADZe", service.Paste{Name: "b", Expire: time.Now().Add(time.Hour)}, nop); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Consume(ctx, "7ZlwE4ADZe"); !db.IsNoSuchPaste(err) {
+		t.Errorf("consuming a paste that is not burnable is %v, want no such paste", err)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	db := servicetest.NewRepo(t)
+
+	now := time.Now()
+
+	pastes := []struct {
+		id   string
+		p    service.Paste
+		burn bool
+	}{
+		{id: "live", p: service.Paste{Name: "shared", Expire: now.Add(time.Hour)}},
+		{id: "expired", p: service.Paste{Name: "shared", Expire: now.Add(-time.Hour)}},
+		{id: "expired-own", p: service.Paste{Name: "own", Expire: now.Add(-time.Hour)}},
+		{id: "burned", p: service.Paste{Name: "burned", Expire: now.Add(time.Hour), BurnAfter: 1, IsBurnable: true}, burn: true},
+	}
+
+	for _, p := range pastes {
+		if err := db.CreatePaste(ctx, p.id, p.p, nop); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.burn {
+			if _, err := db.Consume(ctx, p.id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	before := now.Add(time.Second)
+
+	expired, burned, err := db.DeleteExpired(ctx, before, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, b, err := db.DeleteExpired(ctx, before, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expired+e != 2 || burned+b != 1 {
+		t.Errorf("deleted %d expired and %d burned, want 2 and 1", expired+e, burned+b)
+	}
+
+	if e, b, err = db.DeleteExpired(ctx, before, 2); err != nil || e+b != 0 {
+		t.Errorf("deleted %d more, %v, want none", e+b, err)
+	}
+
+	for _, p := range pastes {
+		_, err := db.GetPaste(ctx, p.id)
+		if gone := db.IsNoSuchPaste(err); gone != (p.id != "live") {
+			t.Errorf("paste %s is gone %v", p.id, gone)
+		}
+	}
+
+	// The shared blob is still referenced by the live paste.
+	names, err := db.UnreferencedBlobs(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(names, ","); got != "burned,own" {
+		t.Errorf("unreferenced blobs are %s, want burned,own", got)
+	}
+
+	for _, name := range names {
+		if err = db.DeleteBlob(ctx, name, nop); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = db.DeleteBlob(ctx, "shared", nop); !db.IsNoSuchPaste(err) {
+		t.Errorf("deleting a referenced blob is %v, want no such paste", err)
+	}
+}
+
+// Writers go on while a blob is removed, an upload of the same content
+// waits for the removal and places the content again.
+func TestDeleteBlob(t *testing.T) {
+	ctx := context.Background()
+	db := servicetest.NewRepo(t)
+	expire := time.Now().Add(time.Hour)
+
+	for _, name := range []string{"blob", "failed"} {
+		if err := db.CreatePaste(ctx, name, service.Paste{Name: name, Expire: expire}, nop); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.DeletePaste(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var placed int
+
+	place := func(context.Context) error {
+		placed++
+		return nil
+	}
+
+	done := make(chan error, 1)
+
+	remove := func(ctx context.Context) error {
+		if err := db.CreatePaste(ctx, "4Gp3gCWeXl", service.Paste{Name: "other", Expire: expire}, nop); err != nil {
+			t.Errorf("write while removing is %v", err)
+		}
+
+		go func() {
+			done <- db.CreatePaste(ctx, "7ZlwE4ADZe", service.Paste{Name: "blob", Expire: expire}, place)
+		}()
+
+		select {
+		case <-done:
+			t.Error("upload took the blob being removed")
+		case <-time.After(300 * time.Millisecond):
+		}
+
+		return nil
+	}
+
+	if err := db.DeleteBlob(ctx, "blob", remove); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if placed != 1 {
+		t.Errorf("placed %d times, want 1", placed)
+	}
+
+	// A failed removal leaves the blob to the next attempt, until then it
+	// can be taken again.
+	errRemove := errors.New("storage down")
+
+	if err := db.DeleteBlob(ctx, "failed", func(context.Context) error { return errRemove }); !errors.Is(err, errRemove) {
+		t.Errorf("failed removal is %v, want %v", err, errRemove)
+	}
+
+	names, err := db.UnreferencedBlobs(ctx, "", 10)
+	if err != nil || strings.Join(names, ",") != "failed" {
+		t.Errorf("unreferenced blobs are %v, %v, want failed", names, err)
+	}
+
+	if err = db.CreatePaste(ctx, "Xy8dwQ1pLm", service.Paste{Name: "failed", Expire: expire}, place); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := db.BlobStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Blobs != 3 || placed != 2 {
+		t.Errorf("%d blobs placed %d times, want 3 placed 2 times", st.Blobs, placed)
+	}
+}
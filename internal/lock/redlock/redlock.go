@@ -42,6 +42,9 @@ func New(addr, username, password string, db int) (*Redlock, error) {
 	}, nil
 }
 
+// lockTTL is how long a lock outlives its holder, Refresh extends it.
+const lockTTL = 5 * time.Second
+
 type mutex struct {
 	*redislock.Lock
 }
@@ -50,16 +53,25 @@ func (m *mutex) Unlock(ctx context.Context) error {
 	return m.Release(ctx)
 }
 
+// Refresh extends the lock by its TTL, it fails once the lock has lapsed.
+func (m *mutex) Refresh(ctx context.Context) error {
+	return m.Lock.Refresh(ctx, lockTTL, nil)
+}
+
 func (l *Redlock) Lock(ctx context.Context, id string) (service.Mutex, error) {
 	opts := redislock.Options{
 		RetryStrategy: redislock.ExponentialBackoff(50*time.Millisecond, 5*time.Second),
 		Metadata:      "",
 		Token:         "",
 	}
-	lock, err := l.locker.Obtain(ctx, id, 5*time.Second, &opts)
+	lock, err := l.locker.Obtain(ctx, id, lockTTL, &opts)
 	if err != nil {
 		return nil, err
 	}
 
 	return &mutex{lock}, nil
 }
+
+func (l *Redlock) Ping(ctx context.Context) error {
+	return l.client.Ping(ctx).Err()
+}
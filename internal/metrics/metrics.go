@@ -0,0 +1,121 @@
+// Package metrics exposes Prometheus metrics of the app. Backends are
+// instrumented by wrapping them before they are handed to the service.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gopetbin"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	pastes          *prometheus.CounterVec
+	cache           *prometheus.CounterVec
+	lockWait        prometheus.Histogram
+	backendDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		pastes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pastes_total",
+			Help:      "Paste events: create, read, burn, expire (deleted by the cleaner).",
+		}, []string{"event"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_requests_total",
+			Help:      "Cache lookups by cache and result: hit, miss, error.",
+		}, []string{"cache", "result"}),
+		lockWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "lock_wait_seconds",
+			Help:      "Time spent acquiring paste locks.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		backendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_duration_seconds",
+			Help:      "Storage and database call latency by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "op", "status"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.pastes,
+		m.cache,
+		m.lockWait,
+		m.backendDuration,
+	)
+
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest implements server.Observer.
+func (m *Metrics) ObserveRequest(method, route string, code int, d time.Duration) {
+	m.requests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	m.requestDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+// PasteEvent implements service.Metrics.
+func (m *Metrics) PasteEvent(event string) {
+	m.pastes.WithLabelValues(event).Inc()
+}
+
+// PasteEvents implements cleaner.Metrics.
+func (m *Metrics) PasteEvents(event string, n int) {
+	m.pastes.WithLabelValues(event).Add(float64(n))
+}
+
+func (m *Metrics) observeBackend(backend, op string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	m.backendDuration.WithLabelValues(backend, op, status).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) observeCache(cache string, err error, isMiss func(error) bool) {
+	result := "hit"
+
+	switch {
+	case err == nil:
+	case isMiss(err):
+		result = "miss"
+	default:
+		result = "error"
+	}
+
+	m.cache.WithLabelValues(cache, result).Inc()
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/swmh/gopetbin/internal/server"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+)
+
+func TestBackends(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	strg := m.Storage(memory.New())
+	repo := m.Repository(servicetest.NewRepo(t))
+
+	if err := strg.PutFile(ctx, "a", strings.NewReader("lorem"), 5, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := strg.GetFile(ctx, "missing"); err == nil {
+		t.Fatal("got a missing file")
+	}
+
+	if err := repo.CreatePaste(ctx, "4Gp3gCWeXl", service.Paste{Name: "a"}, func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := repo.GetPaste(ctx, "4Gp3gCWeXl"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[[3]string]uint64{
+		{"storage", "put", "ok"}:    1,
+		{"storage", "get", "error"}: 1,
+		{"db", "create", "ok"}:      1,
+		{"db", "get", "ok"}:         2,
+	}
+
+	if n := testutil.CollectAndCount(m.backendDuration); n != len(want) {
+		t.Errorf("got %d backend series, want %d", n, len(want))
+	}
+
+	for labels, count := range want {
+		if got := histogramCount(t, m, labels); got != count {
+			t.Errorf("%v observed %d times, want %d", labels, got, count)
+		}
+	}
+}
+
+func histogramCount(t *testing.T, m *Metrics, labels [3]string) uint64 {
+	t.Helper()
+
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range families {
+		if f.GetName() != "gopetbin_backend_duration_seconds" {
+			continue
+		}
+
+		for _, metric := range f.GetMetric() {
+			got := make(map[string]string)
+			for _, l := range metric.GetLabel() {
+				got[l.GetName()] = l.GetValue()
+			}
+
+			if got["backend"] == labels[0] && got["op"] == labels[1] && got["status"] == labels[2] {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestObserver(t *testing.T) {
+	m := New()
+
+	srv := server.New(server.Config{
+		Service:  servicetest.NewService(t, service.Config{}),
+		Logger:   servicetest.Logger(),
+		Observer: m,
+	})
+
+	for _, target := range []string{"/4Gp3gCWeXl", "/7ZlwE4ADZe", "/4Gp3gCWeXl/rev/2", "/healthz"} {
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPatch, "/4Gp3gCWeXl/rev/2/raw", nil))
+
+	tests := []struct {
+		method, route, code string
+		want                float64
+	}{
+		{"GET", "/{id}", "404", 2},
+		{"GET", "/{id}/rev/{rev}", "404", 1},
+		{"GET", "/healthz", "200", 1},
+		{"PATCH", "unmatched", "404", 1},
+	}
+
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.requests.WithLabelValues(tt.method, tt.route, tt.code)); got != tt.want {
+			t.Errorf("%s %s %s counted %v, want %v", tt.method, tt.route, tt.code, got, tt.want)
+		}
+	}
+
+	// Routes are labeled by pattern, ids never become label values.
+	if n := testutil.CollectAndCount(m.requests); n != len(tests) {
+		t.Errorf("got %d request series, want %d", n, len(tests))
+	}
+
+	if n := testutil.CollectAndCount(m.requestDuration); n != len(tests) {
+		t.Errorf("got %d duration series, want %d", n, len(tests))
+	}
+}
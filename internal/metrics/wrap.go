@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/service"
+)
+
+type storage struct {
+	service.Storage
+	m *Metrics
+}
+
+// Storage wraps s to record call latencies.
+func (m *Metrics) Storage(s service.Storage) service.Storage {
+	return &storage{s, m}
+}
+
+func (s *storage) PutFile(ctx context.Context, name string, data io.Reader, size int64, codec string) (err error) {
+	defer func(start time.Time) { s.m.observeBackend("storage", "put", start, err) }(time.Now())
+	return s.Storage.PutFile(ctx, name, data, size, codec)
+}
+
+func (s *storage) GetFile(ctx context.Context, name string) (_ io.ReadCloser, err error) {
+	defer func(start time.Time) { s.m.observeBackend("storage", "get", start, err) }(time.Now())
+	return s.Storage.GetFile(ctx, name)
+}
+
+func (s *storage) GetFileRange(ctx context.Context, name string, offset, length int64) (_ io.ReadCloser, err error) {
+	defer func(start time.Time) { s.m.observeBackend("storage", "get_range", start, err) }(time.Now())
+	return s.Storage.GetFileRange(ctx, name, offset, length)
+}
+
+func (s *storage) FileSize(ctx context.Context, name string) (_ int64, err error) {
+	defer func(start time.Time) { s.m.observeBackend("storage", "stat", start, err) }(time.Now())
+	return s.Storage.FileSize(ctx, name)
+}
+
+func (s *storage) MoveFile(ctx context.Context, src, dst string) (err error) {
+	defer func(start time.Time) { s.m.observeBackend("storage", "move", start, err) }(time.Now())
+	return s.Storage.MoveFile(ctx, src, dst)
+}
+
+func (s *storage) DeleteFile(ctx context.Context, name string) (err error) {
+	defer func(start time.Time) { s.m.observeBackend("storage", "delete", start, err) }(time.Now())
+	return s.Storage.DeleteFile(ctx, name)
+}
+
+type repo struct {
+	service.Repository
+	m *Metrics
+}
+
+// Repository wraps r to record call latencies.
+func (m *Metrics) Repository(r service.Repository) service.Repository {
+	return &repo{r, m}
+}
+
+func (r *repo) CreatePaste(ctx context.Context, id string, paste service.Paste, place service.BlobFunc) (err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "create", start, err) }(time.Now())
+	return r.Repository.CreatePaste(ctx, id, paste, place)
+}
+
+func (r *repo) GetPaste(ctx context.Context, id string) (_ service.Paste, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "get", start, err) }(time.Now())
+	return r.Repository.GetPaste(ctx, id)
+}
+
+func (r *repo) Consume(ctx context.Context, id string) (_ int, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "consume", start, err) }(time.Now())
+	return r.Repository.Consume(ctx, id)
+}
+
+func (r *repo) UpdatePaste(ctx context.Context, id string, name string, checksum string, size int64, place service.BlobFunc) (_ int, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "update", start, err) }(time.Now())
+	return r.Repository.UpdatePaste(ctx, id, name, checksum, size, place)
+}
+
+func (r *repo) GetRevision(ctx context.Context, id string, revision int) (_ string, _ string, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "get_revision", start, err) }(time.Now())
+	return r.Repository.GetRevision(ctx, id, revision)
+}
+
+func (r *repo) DeletePaste(ctx context.Context, id string) (_ []string, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "delete", start, err) }(time.Now())
+	return r.Repository.DeletePaste(ctx, id)
+}
+
+func (r *repo) GetUserByKey(ctx context.Context, keyHash string) (_ service.User, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "get_user", start, err) }(time.Now())
+	return r.Repository.GetUserByKey(ctx, keyHash)
+}
+
+func (r *repo) ListPastes(ctx context.Context, owner int64, limit, offset int) (_ []service.ListedPaste, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "list", start, err) }(time.Now())
+	return r.Repository.ListPastes(ctx, owner, limit, offset)
+}
+
+func (r *repo) DeleteBlob(ctx context.Context, name string, remove service.BlobFunc) (err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "delete_blob", start, err) }(time.Now())
+	return r.Repository.DeleteBlob(ctx, name, remove)
+}
+
+func (r *repo) BlobStats(ctx context.Context) (_ service.BlobStats, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "blob_stats", start, err) }(time.Now())
+	return r.Repository.BlobStats(ctx)
+}
+
+func (r *repo) FindPastes(ctx context.Context, f service.PasteFilter) (_ []service.ListedPaste, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "find", start, err) }(time.Now())
+	return r.Repository.FindPastes(ctx, f)
+}
+
+func (r *repo) BlockHash(ctx context.Context, hash string, reason string) (err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "block", start, err) }(time.Now())
+	return r.Repository.BlockHash(ctx, hash, reason)
+}
+
+func (r *repo) UnblockHash(ctx context.Context, hash string) (err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "unblock", start, err) }(time.Now())
+	return r.Repository.UnblockHash(ctx, hash)
+}
+
+func (r *repo) IsBlocked(ctx context.Context, hash string) (_ bool, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "is_blocked", start, err) }(time.Now())
+	return r.Repository.IsBlocked(ctx, hash)
+}
+
+func (r *repo) LegacyBlobs(ctx context.Context, after string, limit int) (_ []string, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "legacy_blobs", start, err) }(time.Now())
+	return r.Repository.LegacyBlobs(ctx, after, limit)
+}
+
+func (r *repo) RenameBlob(ctx context.Context, old, name, checksum string, place service.BlobFunc) (_ []string, err error) {
+	defer func(start time.Time) { r.m.observeBackend("db", "rename_blob", start, err) }(time.Now())
+	return r.Repository.RenameBlob(ctx, old, name, checksum, place)
+}
+
+type cache struct {
+	service.Cache
+	m *Metrics
+}
+
+// Cache wraps c to count hits and misses.
+func (m *Metrics) Cache(c service.Cache) service.Cache {
+	return &cache{c, m}
+}
+
+func (c *cache) Get(ctx context.Context, key string) (string, error) {
+	v, err := c.Cache.Get(ctx, key)
+	c.m.observeCache("paste", err, c.Cache.IsNoSuchPaste)
+
+	return v, err
+}
+
+type fileCache struct {
+	service.FileCache
+	m *Metrics
+}
+
+// FileCache wraps c to count hits and misses.
+func (m *Metrics) FileCache(c service.FileCache) service.FileCache {
+	return &fileCache{c, m}
+}
+
+func (c *fileCache) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	v, err := c.FileCache.Get(ctx, key)
+	c.m.observeCache("file", err, c.FileCache.IsNoSuchPaste)
+
+	return v, err
+}
+
+func (c *fileCache) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	v, err := c.FileCache.GetRange(ctx, key, offset, length)
+	c.m.observeCache("file", err, c.FileCache.IsNoSuchPaste)
+
+	return v, err
+}
+
+type locker struct {
+	service.Locker
+	m *Metrics
+}
+
+// Locker wraps l to record how long acquiring a lock takes.
+func (m *Metrics) Locker(l service.Locker) service.Locker {
+	return &locker{l, m}
+}
+
+func (l *locker) Lock(ctx context.Context, id string) (service.Mutex, error) {
+	start := time.Now()
+	mutex, err := l.Locker.Lock(ctx, id)
+	l.m.lockWait.Observe(time.Since(start).Seconds())
+
+	return mutex, err
+}
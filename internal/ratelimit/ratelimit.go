@@ -0,0 +1,131 @@
+// Package ratelimit implements token bucket rate limiters keyed by client.
+// Redis backed limiters share their buckets across replicas, the in-memory
+// one is used when Redis is not configured or not reachable.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+// Config of a token bucket: Burst requests at once, refilled at Rate
+// requests per second.
+type Config struct {
+	Rate  float64
+	Burst int
+}
+
+// retryAfter is how long it takes for tokens to reach one.
+func (c Config) retryAfter(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) / c.Rate * float64(time.Second)))
+}
+
+// idle is how long it takes for an empty bucket to fill up, after that
+// its state is no different from a new one.
+func (c Config) idle() time.Duration {
+	return time.Duration(float64(c.Burst) / c.Rate * float64(time.Second))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Memory struct {
+	c       Config
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+func NewMemory(c Config) *Memory {
+	return &Memory{
+		c:       c,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+const sweepEvery = 1024
+
+func (m *Memory) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+	if m.calls%sweepEvery == 0 {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(m.c.Burst), last: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(m.c.Burst), b.tokens+now.Sub(b.last).Seconds()*m.c.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, m.c.retryAfter(b.tokens), nil
+	}
+
+	b.tokens--
+
+	return true, 0, nil
+}
+
+func (m *Memory) sweep(now time.Time) {
+	idle := m.c.idle()
+
+	for k, b := range m.buckets {
+		if now.Sub(b.last) > idle {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+type Fallback struct {
+	primary  Limiter
+	fallback Limiter
+	logger   *slog.Logger
+}
+
+// WithFallback asks primary first and falls back to secondary when it
+// fails, so a Redis outage degrades limits to per replica instead of
+// dropping them.
+func WithFallback(primary, secondary Limiter, logger *slog.Logger) *Fallback {
+	return &Fallback{primary, secondary, logger}
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	ok, retryAfter, err := f.primary.Allow(ctx, key)
+	if err == nil {
+		return ok, retryAfter, nil
+	}
+
+	f.logger.Warn("Rate limiter failed, using in-memory fallback", l.ErrorAttr(err))
+
+	return f.fallback.Allow(ctx, key)
+}
+
+// New creates a limiter sharing its buckets through client with an
+// in-memory fallback, or a purely in-memory one if client is nil.
+func New(client *redis.Client, prefix string, c Config, logger *slog.Logger) Limiter {
+	if client == nil {
+		return NewMemory(c)
+	}
+
+	return WithFallback(NewRedis(client, prefix, c), NewMemory(c), logger)
+}
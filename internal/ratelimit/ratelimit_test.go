@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestMemoryBurst(t *testing.T) {
+	tests := []struct {
+		name     string
+		c        Config
+		requests int
+		allowed  int
+	}{
+		{name: "within burst", c: Config{Rate: 0.001, Burst: 3}, requests: 3, allowed: 3},
+		{name: "over burst", c: Config{Rate: 0.001, Burst: 3}, requests: 5, allowed: 3},
+		{name: "no burst", c: Config{Rate: 0.001, Burst: 0}, requests: 2, allowed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory(tt.c)
+
+			var allowed int
+
+			for i := 0; i < tt.requests; i++ {
+				ok, retryAfter, err := m.Allow(context.Background(), "client")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if ok {
+					allowed++
+				} else if retryAfter <= 0 {
+					t.Errorf("rejected with retry after %s", retryAfter)
+				}
+			}
+
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d requests, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestMemoryKeys(t *testing.T) {
+	m := NewMemory(Config{Rate: 0.001, Burst: 1})
+
+	for _, key := range []string{"a", "b"} {
+		if ok, _, _ := m.Allow(context.Background(), key); !ok {
+			t.Errorf("first request of %s rejected", key)
+		}
+	}
+
+	if ok, _, _ := m.Allow(context.Background(), "a"); ok {
+		t.Error("second request of a allowed")
+	}
+}
+
+func TestMemoryRefill(t *testing.T) {
+	m := NewMemory(Config{Rate: 1000, Burst: 1})
+
+	m.Allow(context.Background(), "client")
+	time.Sleep(5 * time.Millisecond)
+
+	if ok, _, _ := m.Allow(context.Background(), "client"); !ok {
+		t.Error("request after refill rejected")
+	}
+}
+
+type failing struct{}
+
+func (failing) Allow(context.Context, string) (bool, time.Duration, error) {
+	return false, 0, errors.New("redis down")
+}
+
+func TestFallback(t *testing.T) {
+	f := WithFallback(failing{}, NewMemory(Config{Rate: 0.001, Burst: 1}), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ok, _, err := f.Allow(context.Background(), "client")
+	if err != nil || !ok {
+		t.Fatalf("first request is %v, %v, want allowed by the fallback", ok, err)
+	}
+
+	if ok, _, _ = f.Allow(context.Background(), "client"); ok {
+		t.Error("fallback let the second request through")
+	}
+}
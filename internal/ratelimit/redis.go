@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/swmh/gopetbin/pkg/retry"
+)
+
+// tokenBucket refills and takes a token from the bucket at KEYS[1] in one
+// step. ARGV is the rate per second and the burst, it returns whether the
+// request is allowed and otherwise how many milliseconds to wait. Redis
+// time is used so replicas with skewed clocks agree.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1]) or burst
+local last = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + (now - last) / 1000 * rate)
+
+local allowed = 0
+local wait = 0
+
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+
+return {allowed, wait}
+`)
+
+type Redis struct {
+	client *redis.Client
+	prefix string
+	c      Config
+}
+
+func NewRedisClient(addr, username, password string, db int) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Username: username,
+		Password: password,
+		DB:       db,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	err := retry.Retry(ctx, func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to rate limiter: %w", err)
+	}
+
+	return client, nil
+}
+
+// NewRedis creates a limiter keeping its buckets under prefix, limiters with
+// different configs must use different prefixes.
+func NewRedis(client *redis.Client, prefix string, c Config) *Redis {
+	return &Redis{
+		client: client,
+		prefix: prefix,
+		c:      c,
+	}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	res, err := tokenBucket.Run(ctx, r.client, []string{r.prefix + key}, r.c.Rate, r.c.Burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected script result %v", res)
+	}
+
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+// PasteFilter selects pastes for Service.FindPastes, empty fields match
+// everything.
+type PasteFilter struct {
+	ID     string
+	Hash   string
+	Limit  int
+	Offset int
+}
+
+// BlobStats sums up the deduplication of stored content, sizes are of the
+// content before compression.
+type BlobStats struct {
+	Blobs      int64
+	References int64
+	Size       int64
+	Saved      int64
+}
+
+const maxBlockRequestSize = 4 << 10
+
+// requireAdmin lets only requests authenticated with an admin key through.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gopetbin"`)
+			writeJSONError(w, http.StatusUnauthorized, "api key required")
+
+			return
+		}
+
+		if !user.Admin {
+			writeJSONError(w, http.StatusForbidden, "admin required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type apiAdminPaste struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	Hash           string    `json:"hash"`
+	Size           int64     `json:"size"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpireAt       time.Time `json:"expire_at"`
+	RemainingReads *int      `json:"remaining_reads"`
+	Revision       int       `json:"revision"`
+	Lang           string    `json:"lang,omitempty"`
+	Encrypted      bool      `json:"encrypted"`
+	CreatorIP      string    `json:"creator_ip,omitempty"`
+	Owner          int64     `json:"owner,omitempty"`
+}
+
+// NewAdminFind lists recent pastes, optionally filtered by the id or the
+// hash query parameter. With an id in the path it returns that paste only.
+func (s *Server) NewAdminFind(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := parseListQuery(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		f := PasteFilter{
+			ID:     strings.TrimSpace(r.URL.Query().Get("id")),
+			Hash:   strings.TrimSpace(r.URL.Query().Get("hash")),
+			Limit:  limit,
+			Offset: offset,
+		}
+
+		id := strings.TrimSpace(chi.URLParam(r, "id"))
+		if id != "" {
+			f.ID = id
+		}
+
+		infos, err := s.service.FindPastes(r.Context(), f)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot find pastes", l.ErrorAttr(err))
+
+			return
+		}
+
+		pastes := make([]apiAdminPaste, 0, len(infos))
+
+		for _, info := range infos {
+			p := apiAdminPaste{
+				ID:        info.ID,
+				URL:       s.pasteURL(info.ID),
+				Hash:      info.Hash,
+				Size:      info.Size,
+				CreatedAt: info.Created,
+				ExpireAt:  info.Expire,
+				Revision:  info.Revision,
+				Lang:      info.Lang,
+				Encrypted: info.Encrypted,
+				CreatorIP: info.CreatorIP,
+				Owner:     info.Owner,
+			}
+
+			if info.IsBurnable {
+				reads := info.BurnAfter
+				p.RemainingReads = &reads
+			}
+
+			pastes = append(pastes, p)
+		}
+
+		if id != "" {
+			if len(pastes) == 0 {
+				writeJSONError(w, http.StatusNotFound, "not found")
+				return
+			}
+
+			writeJSON(w, http.StatusOK, pastes[0])
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, pastes)
+	}
+}
+
+// NewAdminDelete removes a paste and its content without a delete token.
+func (s *Server) NewAdminDelete(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(chi.URLParam(r, "id"))
+		if id == "" {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		err := s.service.ForceDeletePaste(r.Context(), id)
+		if err != nil {
+			if s.service.IsNoSuchPaste(err) {
+				writeJSONError(w, http.StatusNotFound, "not found")
+				return
+			}
+
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot delete paste", l.ErrorAttr(err))
+
+			return
+		}
+
+		logger.Info("Paste deleted by admin", slog.String("id", id), slog.String("admin", adminName(r)))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type apiBlockRequest struct {
+	Reason string `json:"reason"`
+}
+
+// NewAdminBlock blocks a content hash, the body is optional.
+func (s *Server) NewAdminBlock(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimSpace(chi.URLParam(r, "hash"))
+		if hash == "" {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		var req apiBlockRequest
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBlockRequestSize)
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		if err := s.service.BlockHash(r.Context(), hash, strings.TrimSpace(req.Reason)); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot block hash", l.ErrorAttr(err))
+
+			return
+		}
+
+		logger.Info("Hash blocked", slog.String("hash", hash), slog.String("admin", adminName(r)))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) NewAdminUnblock(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimSpace(chi.URLParam(r, "hash"))
+		if hash == "" {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		err := s.service.UnblockHash(r.Context(), hash)
+		if err != nil {
+			if s.service.IsNoSuchPaste(err) {
+				writeJSONError(w, http.StatusNotFound, "not found")
+				return
+			}
+
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot unblock hash", l.ErrorAttr(err))
+
+			return
+		}
+
+		logger.Info("Hash unblocked", slog.String("hash", hash), slog.String("admin", adminName(r)))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type apiBlobStats struct {
+	Blobs      int64 `json:"blobs"`
+	References int64 `json:"references"`
+	Size       int64 `json:"size"`
+	SavedSize  int64 `json:"saved_size"`
+}
+
+// NewAdminStats reports how much storage deduplication saves.
+func (s *Server) NewAdminStats(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.service.BlobStats(r.Context())
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot get blob stats", l.ErrorAttr(err))
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, apiBlobStats{
+			Blobs:      st.Blobs,
+			References: st.References,
+			Size:       st.Size,
+			SavedSize:  st.Saved,
+		})
+	}
+}
+
+func adminName(r *http.Request) string {
+	u, _ := UserFromContext(r.Context())
+	return u.Name
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newModerationService holds two pastes for admins to find and one blocked
+// hash.
+func newModerationService() *fakeService {
+	return &fakeService{
+		found: []PasteInfo{
+			{ID: "4Gp3gCWeXl", Hash: "aaa", CreatorIP: "203.0.113.7"},
+			{ID: "7ZlwE4ADZe", Hash: "bbb", Owner: 2},
+		},
+		blocked: map[string]string{"ccc": "spam"},
+		stats:   BlobStats{Blobs: 1, References: 2, Size: 10, Saved: 10},
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	routes := []struct {
+		method, target string
+	}{
+		{http.MethodGet, "/api/v1/admin/pastes"},
+		{http.MethodGet, "/api/v1/admin/pastes/4Gp3gCWeXl"},
+		{http.MethodDelete, "/api/v1/admin/pastes/4Gp3gCWeXl"},
+		{http.MethodPut, "/api/v1/admin/blocks/aaa"},
+		{http.MethodDelete, "/api/v1/admin/blocks/ccc"},
+		{http.MethodGet, "/api/v1/admin/stats"},
+	}
+
+	keys := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{name: "no key", want: http.StatusUnauthorized},
+		{name: "wrong key", auth: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "user key", auth: "Bearer bob-key", want: http.StatusForbidden},
+	}
+
+	for _, rt := range routes {
+		for _, k := range keys {
+			t.Run(rt.method+" "+rt.target+" "+k.name, func(t *testing.T) {
+				svc := newModerationService()
+				srv := New(Config{
+					Service: svc,
+					Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+				})
+
+				r := httptest.NewRequest(rt.method, rt.target, nil)
+				if k.auth != "" {
+					r.Header.Set("Authorization", k.auth)
+				}
+
+				w := httptest.NewRecorder()
+				srv.server.Handler.ServeHTTP(w, r)
+
+				if w.Code != k.want {
+					t.Errorf("status is %d, want %d", w.Code, k.want)
+				}
+
+				if len(svc.filters) != 0 || len(svc.deleted) != 0 || len(svc.blocked) != 1 {
+					t.Error("the request reached the service")
+				}
+			})
+		}
+	}
+}
+
+func TestAdminFind(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		ids    []string
+	}{
+		{name: "all", target: "/api/v1/admin/pastes", want: http.StatusOK, ids: []string{"4Gp3gCWeXl", "7ZlwE4ADZe"}},
+		{name: "by hash", target: "/api/v1/admin/pastes?hash=bbb", want: http.StatusOK, ids: []string{"7ZlwE4ADZe"}},
+		{name: "by id", target: "/api/v1/admin/pastes?id=4Gp3gCWeXl", want: http.StatusOK, ids: []string{"4Gp3gCWeXl"}},
+		{name: "no match", target: "/api/v1/admin/pastes?hash=zzz", want: http.StatusOK, ids: []string{}},
+		{name: "bad limit", target: "/api/v1/admin/pastes?limit=x", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: newModerationService(),
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Authorization", "Bearer admin-key")
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var pastes []apiAdminPaste
+			if err := json.NewDecoder(w.Body).Decode(&pastes); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(pastes) != len(tt.ids) {
+				t.Fatalf("found %d pastes, want %d", len(pastes), len(tt.ids))
+			}
+
+			for i, p := range pastes {
+				if p.ID != tt.ids[i] {
+					t.Errorf("paste %d is %s, want %s", i, p.ID, tt.ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAdminFindOne(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   int
+		ip     string
+	}{
+		{name: "found", target: "/api/v1/admin/pastes/4Gp3gCWeXl", want: http.StatusOK, ip: "203.0.113.7"},
+		{name: "missing", target: "/api/v1/admin/pastes/missing", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: newModerationService(),
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Authorization", "Bearer admin-key")
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var p apiAdminPaste
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.CreatorIP != tt.ip {
+				t.Errorf("creator ip is %q, want %q", p.CreatorIP, tt.ip)
+			}
+		})
+	}
+}
+
+func TestAdminModerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		want    int
+		deleted []string
+		blocked map[string]string
+	}{
+		{
+			name:    "force delete",
+			method:  http.MethodDelete,
+			target:  "/api/v1/admin/pastes/4Gp3gCWeXl",
+			want:    http.StatusNoContent,
+			deleted: []string{"4Gp3gCWeXl"},
+			blocked: map[string]string{"ccc": "spam"},
+		},
+		{
+			name:    "force delete missing",
+			method:  http.MethodDelete,
+			target:  "/api/v1/admin/pastes/missing",
+			want:    http.StatusNotFound,
+			blocked: map[string]string{"ccc": "spam"},
+		},
+		{
+			name:    "block",
+			method:  http.MethodPut,
+			target:  "/api/v1/admin/blocks/aaa",
+			body:    `{"reason": " malware "}`,
+			want:    http.StatusNoContent,
+			blocked: map[string]string{"aaa": "malware", "ccc": "spam"},
+		},
+		{
+			name:    "block without body",
+			method:  http.MethodPut,
+			target:  "/api/v1/admin/blocks/aaa",
+			want:    http.StatusNoContent,
+			blocked: map[string]string{"aaa": "", "ccc": "spam"},
+		},
+		{
+			name:    "block bad body",
+			method:  http.MethodPut,
+			target:  "/api/v1/admin/blocks/aaa",
+			body:    `{"reason":`,
+			want:    http.StatusBadRequest,
+			blocked: map[string]string{"ccc": "spam"},
+		},
+		{
+			name:    "unblock",
+			method:  http.MethodDelete,
+			target:  "/api/v1/admin/blocks/ccc",
+			want:    http.StatusNoContent,
+			blocked: map[string]string{},
+		},
+		{
+			name:    "unblock missing",
+			method:  http.MethodDelete,
+			target:  "/api/v1/admin/blocks/zzz",
+			want:    http.StatusNotFound,
+			blocked: map[string]string{"ccc": "spam"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newModerationService()
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer admin-key")
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if strings.Join(svc.deleted, ",") != strings.Join(tt.deleted, ",") {
+				t.Errorf("deleted %v, want %v", svc.deleted, tt.deleted)
+			}
+
+			if len(svc.blocked) != len(tt.blocked) {
+				t.Fatalf("blocked %v, want %v", svc.blocked, tt.blocked)
+			}
+
+			for hash, reason := range tt.blocked {
+				if got, ok := svc.blocked[hash]; !ok || got != reason {
+					t.Errorf("blocked %v, want %v", svc.blocked, tt.blocked)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	srv := New(Config{
+		Service: newModerationService(),
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/stats", nil)
+	r.Header.Set("Authorization", "Bearer admin-key")
+
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var st apiBlobStats
+	if err := json.NewDecoder(w.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+
+	want := apiBlobStats{Blobs: 1, References: 2, Size: 10, SavedSize: 10}
+	if st != want {
+		t.Errorf("stats are %+v, want %+v", st, want)
+	}
+}
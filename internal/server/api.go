@@ -0,0 +1,366 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+type apiCreateRequest struct {
+	Content   *string `json:"content"`
+	Expire    string  `json:"expire"`
+	Burn      int     `json:"burn"`
+	Lang      string  `json:"lang"`
+	Password  string  `json:"password"`
+	Encrypted bool    `json:"encrypted"`
+
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+}
+
+type apiUpdateRequest struct {
+	Content *string `json:"content"`
+}
+
+type apiPaste struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	ExpireAt       time.Time `json:"expire_at"`
+	RemainingReads *int      `json:"remaining_reads"`
+	Size           int64     `json:"size"`
+	Revision       int       `json:"revision"`
+	Lang           string    `json:"lang,omitempty"`
+	Encrypted      bool      `json:"encrypted"`
+	ContentType    string    `json:"content_type,omitempty"`
+	Filename       string    `json:"filename,omitempty"`
+	Content        *string   `json:"content,omitempty"`
+	Encoding       string    `json:"encoding,omitempty"` /* base64 if content is not UTF-8 */
+	DeleteToken    string    `json:"delete_token,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+	URL   string `json:"url,omitempty"` /* raw content too large for JSON */
+}
+
+// maxAPIContent limits the content embedded in JSON responses, which are
+// built in memory. Larger pastes are read from their raw URL.
+const maxAPIContent = 1 << 20
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+func (s *Server) newAPIPaste(info PasteInfo) apiPaste {
+	p := apiPaste{
+		ID:          info.ID,
+		URL:         s.pasteURL(info.ID),
+		ExpireAt:    info.Expire,
+		Size:        info.Size,
+		Revision:    info.Revision,
+		Lang:        info.Lang,
+		Encrypted:   info.Encrypted,
+		ContentType: info.ContentType,
+		Filename:    info.Filename,
+	}
+
+	if info.IsBurnable {
+		reads := info.BurnAfter
+		p.RemainingReads = &reads
+	}
+
+	return p
+}
+
+func (s *Server) parseAPIRequest(r io.Reader) (Paste, error) {
+	var req apiCreateRequest
+
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		var largeErr *http.MaxBytesError
+		if errors.As(err, &largeErr) {
+			return Paste{}, err
+		}
+
+		return Paste{}, errors.Join(err, errBadValue)
+	}
+
+	if req.Content == nil {
+		return Paste{}, errBadValue
+	}
+
+	var expire time.Duration
+	var err error
+
+	if req.Expire != "" {
+		expire, err = parseExpire(req.Expire)
+		if err != nil {
+			return Paste{}, err
+		}
+	}
+
+	if err = validateBurn(req.Burn); err != nil {
+		return Paste{}, err
+	}
+
+	if err = validateLang(req.Lang); err != nil {
+		return Paste{}, err
+	}
+
+	if err = validatePassword(req.Password); err != nil {
+		return Paste{}, err
+	}
+
+	ct, err := parseContentType(req.ContentType)
+	if err != nil {
+		return Paste{}, err
+	}
+
+	if err = validateFilename(req.Filename); err != nil {
+		return Paste{}, err
+	}
+
+	return Paste{
+		Expire:      expire,
+		BurnAfter:   req.Burn,
+		Lang:        req.Lang,
+		Password:    req.Password,
+		Encrypted:   req.Encrypted,
+		Content:     strings.NewReader(*req.Content),
+		Size:        int64(len(*req.Content)),
+		ContentType: ct,
+		Filename:    req.Filename,
+	}, nil
+}
+
+func (s *Server) NewAPICreate(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxSizeFor(r))
+
+		paste, err := s.parseAPIRequest(r.Body)
+		if err != nil {
+			var largeErr *http.MaxBytesError
+			if errors.As(err, &largeErr) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "message too large")
+				return
+			}
+
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+
+			return
+		}
+
+		if !s.validExpire(r, paste.Expire) {
+			writeJSONError(w, http.StatusBadRequest, "expire exceeds limit")
+			return
+		}
+
+		paste.Owner = owner(r)
+		paste.CreatorIP = s.clientIP(r)
+
+		info, err := s.service.CreatePaste(r.Context(), paste)
+		if err != nil {
+			if s.service.IsBlocked(err) {
+				writeJSONError(w, http.StatusForbidden, "content blocked")
+				return
+			}
+
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot create paste", l.ErrorAttr(err))
+
+			return
+		}
+
+		p := s.newAPIPaste(info)
+		p.DeleteToken = info.DeleteToken
+
+		writeJSON(w, http.StatusCreated, p)
+	}
+}
+
+// writeAPIGetError answers a failed read of a paste in JSON.
+func writeAPIGetError(w http.ResponseWriter, logger *slog.Logger, svc Service, err error) {
+	switch {
+	case svc.IsNoSuchPaste(err):
+		writeJSONError(w, http.StatusNotFound, "not found")
+	case svc.IsWrongPassword(err):
+		writeJSONError(w, http.StatusUnauthorized, "wrong password")
+	case svc.IsTooManyAttempts(err):
+		writeJSONError(w, http.StatusTooManyRequests, "too many password attempts")
+	default:
+		writeJSONError(w, http.StatusInternalServerError, "internal server error")
+		logger.Error("Cannot get file", l.ErrorAttr(err))
+	}
+}
+
+// rawURL returns where the content of the queried revision is served as is.
+func (s *Server) rawURL(q Query) string {
+	if q.Revision > 0 {
+		return fmt.Sprintf("%s/rev/%d", s.pasteURL(q.ID), q.Revision)
+	}
+
+	return s.pasteURL(q.ID)
+}
+
+func (s *Server) NewAPIGet(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		tooLarge := func() {
+			writeJSON(w, http.StatusRequestEntityTooLarge, apiError{Error: "content too large for JSON", URL: s.rawURL(q)})
+		}
+
+		// Large pastes are turned away before the read, which may be the
+		// last one of a burnable paste.
+		info, err := s.service.StatPaste(r.Context(), q)
+		if err != nil {
+			writeAPIGetError(w, logger, s.service, err)
+			return
+		}
+
+		if info.Size > maxAPIContent {
+			tooLarge()
+			return
+		}
+
+		q.Authorized = true
+
+		info, file, err := s.service.GetPaste(r.Context(), q)
+		defer func() {
+			if file != nil {
+				file.Close()
+			}
+		}()
+
+		if err != nil {
+			writeAPIGetError(w, logger, s.service, err)
+			return
+		}
+
+		// Pastes stored before sizes were recorded report none.
+		data, err := io.ReadAll(io.LimitReader(file, maxAPIContent+1))
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot read file", l.ErrorAttr(err))
+
+			return
+		}
+
+		if len(data) > maxAPIContent {
+			tooLarge()
+			return
+		}
+
+		p := s.newAPIPaste(info)
+		p.Size = int64(len(data))
+
+		// JSON strings are UTF-8, other content would be mangled into
+		// replacement characters.
+		content := string(data)
+		if !utf8.Valid(data) {
+			content = base64.StdEncoding.EncodeToString(data)
+			p.Encoding = "base64"
+		}
+
+		p.Content = &content
+
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+func (s *Server) NewAPIDelete(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(chi.URLParam(r, "id"))
+		token := deleteToken(r)
+
+		if id == "" || token == "" {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		err := s.service.DeletePaste(r.Context(), id, token)
+		if err != nil {
+			switch {
+			case s.service.IsNoSuchPaste(err):
+				writeJSONError(w, http.StatusNotFound, "not found")
+			case s.service.IsInvalidToken(err):
+				writeJSONError(w, http.StatusForbidden, "invalid delete token")
+			default:
+				writeJSONError(w, http.StatusInternalServerError, "internal server error")
+				logger.Error("Cannot delete paste", l.ErrorAttr(err))
+			}
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) NewAPIUpdate(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(chi.URLParam(r, "id"))
+		token := deleteToken(r)
+
+		if id == "" || token == "" {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxSizeFor(r))
+
+		var req apiUpdateRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Content == nil {
+			var largeErr *http.MaxBytesError
+			if errors.As(err, &largeErr) {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "message too large")
+				return
+			}
+
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+
+			return
+		}
+
+		content := strings.NewReader(*req.Content)
+
+		info, err := s.service.UpdatePaste(r.Context(), id, token, content, content.Size())
+		if err != nil {
+			switch {
+			case s.service.IsNoSuchPaste(err):
+				writeJSONError(w, http.StatusNotFound, "not found")
+			case s.service.IsInvalidToken(err):
+				writeJSONError(w, http.StatusForbidden, "invalid delete token")
+			case s.service.IsBlocked(err):
+				writeJSONError(w, http.StatusForbidden, "content blocked")
+			default:
+				writeJSONError(w, http.StatusInternalServerError, "internal server error")
+				logger.Error("Cannot update paste", l.ErrorAttr(err))
+			}
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, s.newAPIPaste(info))
+	}
+}
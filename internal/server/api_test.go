@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIGetContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     string
+		encoding string
+	}{
+		{name: "text", content: "lorem ipsum ✓", want: "lorem ipsum ✓"},
+		{name: "binary", content: "\x89PNG\r\n\x1a\n\xff", want: base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\xff")), encoding: "base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: &fakeService{info: PasteInfo{Size: int64(len(tt.content))}, content: tt.content},
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/pastes/4Gp3gCWeXl", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var p apiPaste
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Content == nil || *p.Content != tt.want {
+				t.Errorf("content is %v, want %q", p.Content, tt.want)
+			}
+
+			if p.Encoding != tt.encoding {
+				t.Errorf("encoding is %q, want %q", p.Encoding, tt.encoding)
+			}
+		})
+	}
+}
+
+func TestAPIGetTooLarge(t *testing.T) {
+	large := strings.Repeat("a", maxAPIContent+1)
+
+	tests := []struct {
+		name   string
+		target string
+		size   int64
+		want   int
+		url    string
+		reads  int
+	}{
+		{name: "fits", target: "/api/v1/pastes/4Gp3gCWeXl", size: maxAPIContent, want: http.StatusOK, reads: 1},
+		{name: "too large", target: "/api/v1/pastes/4Gp3gCWeXl", size: maxAPIContent + 1, want: http.StatusRequestEntityTooLarge, url: "http://localhost:8080/4Gp3gCWeXl"},
+		{name: "revision", target: "/api/v1/pastes/4Gp3gCWeXl/rev/2", size: maxAPIContent + 1, want: http.StatusRequestEntityTooLarge, url: "http://localhost:8080/4Gp3gCWeXl/rev/2"},
+		{name: "unknown size", target: "/api/v1/pastes/4Gp3gCWeXl", want: http.StatusRequestEntityTooLarge, url: "http://localhost:8080/4Gp3gCWeXl", reads: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := large
+			if tt.want == http.StatusOK {
+				content = large[:tt.size]
+			}
+
+			svc := &fakeService{info: PasteInfo{Size: tt.size}, content: content}
+			srv := New(Config{
+				Service:    svc,
+				Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+				PublicPath: "http://localhost:8080",
+			})
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if len(svc.queries) != tt.reads {
+				t.Errorf("read the paste %d times, want %d", len(svc.queries), tt.reads)
+			}
+
+			if tt.url == "" {
+				return
+			}
+
+			var e apiError
+			if err := json.NewDecoder(w.Body).Decode(&e); err != nil {
+				t.Fatal(err)
+			}
+
+			if e.URL != tt.url {
+				t.Errorf("url is %q, want %q", e.URL, tt.url)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+type User struct {
+	ID    int64
+	Name  string
+	Admin bool
+}
+
+type userKey struct{}
+
+// UserFromContext returns the user authenticated by the API key of the
+// request, ok is false for anonymous requests.
+func UserFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+// bearerToken returns the token of "Authorization: Bearer <token>". Other
+// schemes are ignored, Basic is used for paste passwords.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// authenticate resolves the API key of the request, if it has one, and
+// stores the user in the context. Requests without a key stay anonymous,
+// a wrong key is rejected rather than silently downgraded.
+func (s *Server) authenticate(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := bearerToken(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, err := s.service.Authenticate(r.Context(), key)
+			if err != nil {
+				if s.service.IsUnauthorized(err) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="gopetbin"`)
+					writeJSONError(w, http.StatusUnauthorized, "invalid api key")
+
+					return
+				}
+
+				writeJSONError(w, http.StatusInternalServerError, "internal server error")
+				logger.Error("Cannot authenticate", l.ErrorAttr(err))
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+		})
+	}
+}
+
+// maxSizeFor is the size limit of the request, authenticated users get
+// their own.
+func (s *Server) maxSizeFor(r *http.Request) int64 {
+	if _, ok := UserFromContext(r.Context()); ok && s.userMaxSize > 0 {
+		return s.userMaxSize
+	}
+
+	return s.maxSize
+}
+
+// validExpire checks expire against the limit of the request, 0 means the
+// default expiration and no limit is unlimited.
+func (s *Server) validExpire(r *http.Request, expire time.Duration) bool {
+	limit := s.maxExpire
+	if _, ok := UserFromContext(r.Context()); ok {
+		limit = s.userMaxExpire
+	}
+
+	return limit <= 0 || expire <= limit
+}
+
+// owner is the user id to record on pastes created by the request.
+func owner(r *http.Request) int64 {
+	u, _ := UserFromContext(r.Context())
+	return u.ID
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 100
+)
+
+type apiPasteSummary struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpireAt       time.Time `json:"expire_at"`
+	RemainingReads *int      `json:"remaining_reads"`
+	Revision       int       `json:"revision"`
+	Lang           string    `json:"lang,omitempty"`
+	Encrypted      bool      `json:"encrypted"`
+}
+
+func parseListQuery(r *http.Request) (int, int, error) {
+	limit, offset := defaultListLimit, 0
+
+	var err error
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, errBadValue
+		}
+
+		limit = min(limit, maxListLimit)
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, errBadValue
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// NewAPIMyPastes lists pastes created with the API key of the request.
+func (s *Server) NewAPIMyPastes(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gopetbin"`)
+			writeJSONError(w, http.StatusUnauthorized, "api key required")
+
+			return
+		}
+
+		limit, offset, err := parseListQuery(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "bad request")
+			return
+		}
+
+		infos, err := s.service.ListPastes(r.Context(), user, limit, offset)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "internal server error")
+			logger.Error("Cannot list pastes", l.ErrorAttr(err))
+
+			return
+		}
+
+		pastes := make([]apiPasteSummary, 0, len(infos))
+
+		for _, info := range infos {
+			p := apiPasteSummary{
+				ID:        info.ID,
+				URL:       s.pasteURL(info.ID),
+				CreatedAt: info.Created,
+				ExpireAt:  info.Expire,
+				Revision:  info.Revision,
+				Lang:      info.Lang,
+				Encrypted: info.Encrypted,
+			}
+
+			if info.IsBurnable {
+				reads := info.BurnAfter
+				p.RemainingReads = &reads
+			}
+
+			pastes = append(pastes, p)
+		}
+
+		writeJSON(w, http.StatusOK, pastes)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newUserService lists the pastes of alice.
+func newUserService() *fakeService {
+	return &fakeService{
+		owned: map[int64][]PasteInfo{
+			1: {{ID: "4Gp3gCWeXl", Revision: 1}, {ID: "7ZlwE4ADZe", Revision: 2, IsBurnable: true, BurnAfter: 1}},
+		},
+	}
+}
+
+func TestAPIMyPastes(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		auth   string
+		want   int
+		ids    []string
+		bearer bool
+	}{
+		{name: "no key", target: "/api/v1/me/pastes", want: http.StatusUnauthorized, bearer: true},
+		{name: "basic auth", target: "/api/v1/me/pastes", auth: "Basic YWxpY2U6c2VjcmV0", want: http.StatusUnauthorized, bearer: true},
+		{name: "wrong key", target: "/api/v1/me/pastes", auth: "Bearer guess", want: http.StatusUnauthorized, bearer: true},
+		{name: "key store down", target: "/api/v1/me/pastes", auth: "Bearer broken", want: http.StatusInternalServerError},
+		{name: "owner", target: "/api/v1/me/pastes", auth: "Bearer alice-key", want: http.StatusOK, ids: []string{"4Gp3gCWeXl", "7ZlwE4ADZe"}},
+		{name: "scheme case", target: "/api/v1/me/pastes", auth: "bearer alice-key", want: http.StatusOK, ids: []string{"4Gp3gCWeXl", "7ZlwE4ADZe"}},
+		{name: "other user", target: "/api/v1/me/pastes", auth: "Bearer bob-key", want: http.StatusOK, ids: []string{}},
+		{name: "bad limit", target: "/api/v1/me/pastes?limit=0", auth: "Bearer alice-key", want: http.StatusBadRequest},
+		{name: "bad offset", target: "/api/v1/me/pastes?offset=-1", auth: "Bearer alice-key", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: newUserService(),
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.bearer {
+				t.Errorf("WWW-Authenticate set is %v, want %v", got, tt.bearer)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var pastes []apiPasteSummary
+			if err := json.NewDecoder(w.Body).Decode(&pastes); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(pastes) != len(tt.ids) {
+				t.Fatalf("listed %d pastes, want %d", len(pastes), len(tt.ids))
+			}
+
+			for i, p := range pastes {
+				if p.ID != tt.ids[i] {
+					t.Errorf("paste %d is %s, want %s", i, p.ID, tt.ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestListLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		limit  int
+		offset int
+	}{
+		{name: "default", target: "/api/v1/me/pastes", limit: defaultListLimit},
+		{name: "page", target: "/api/v1/me/pastes?limit=10&offset=20", limit: 10, offset: 20},
+		{name: "capped", target: "/api/v1/me/pastes?limit=1000", limit: maxListLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newUserService()
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set("Authorization", "Bearer alice-key")
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if len(svc.listed) != 2 || svc.listed[0] != tt.limit || svc.listed[1] != tt.offset {
+				t.Errorf("listed with limit, offset %v, want %d, %d", svc.listed, tt.limit, tt.offset)
+			}
+		})
+	}
+}
+
+// Pastes created with a key are owned by its user and get the user limits.
+func TestCreateWithKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   string
+		size   int
+		expire string
+		want   int
+		owner  int64
+	}{
+		{name: "anonymous", size: 10, want: http.StatusCreated},
+		{name: "anonymous too large", size: 100, want: http.StatusRequestEntityTooLarge},
+		{name: "anonymous long expire", size: 10, expire: "48h", want: http.StatusBadRequest},
+		{name: "user", auth: "Bearer alice-key", size: 100, want: http.StatusCreated, owner: 1},
+		{name: "user long expire", auth: "Bearer alice-key", size: 10, expire: "48h", want: http.StatusCreated, owner: 1},
+		{name: "wrong key", auth: "Bearer guess", size: 10, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newUserService()
+			srv := New(Config{
+				Service:       svc,
+				Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				MaxSize:       64,
+				MaxExpire:     time.Hour,
+				UserMaxSize:   1024,
+				UserMaxExpire: 7 * 24 * time.Hour,
+			})
+
+			body := `{"content": "` + strings.Repeat("a", tt.size) + `", "expire": "` + tt.expire + `"}`
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/pastes", strings.NewReader(body))
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusCreated {
+				if len(svc.created) != 0 {
+					t.Error("paste created")
+				}
+
+				return
+			}
+
+			if len(svc.created) != 1 || svc.created[0].Owner != tt.owner {
+				t.Errorf("created %+v, want one owned by %d", svc.created, tt.owner)
+			}
+		})
+	}
+}
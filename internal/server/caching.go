@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAgeLatest caps how long the latest revision may be cached, it can be
+// replaced by an update at any time. Older revisions never change.
+const maxAgeLatest = 5 * time.Minute
+
+// etag is strong, so encoded representations get their own.
+func etag(info PasteInfo) string {
+	if info.Hash == "" {
+		return ""
+	}
+
+	if info.Encoding != "" {
+		return `"` + info.Hash + "-" + info.Encoding + `"`
+	}
+
+	return `"` + info.Hash + `"`
+}
+
+// setCacheHeaders lets clients revalidate the paste. Burnable pastes are
+// never stored, every read of them counts.
+func setCacheHeaders(w http.ResponseWriter, info PasteInfo, latest bool) {
+	h := w.Header()
+
+	// The same URL serves the page or the raw content, depending on Accept.
+	h.Set("Vary", "Accept, Accept-Encoding")
+
+	if tag := etag(info); tag != "" {
+		h.Set("ETag", tag)
+	}
+
+	if !info.Modified.IsZero() {
+		h.Set("Last-Modified", info.Modified.UTC().Format(http.TimeFormat))
+	}
+
+	h.Set("Cache-Control", cacheControl(info, latest, info.Protected))
+}
+
+// setPageCacheHeaders is setCacheHeaders for the highlighted page. It has
+// no validators of its own, so only the browser may keep it.
+func setPageCacheHeaders(w http.ResponseWriter, info PasteInfo, latest bool) {
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+	w.Header().Set("Cache-Control", cacheControl(info, latest, true))
+}
+
+func cacheControl(info PasteInfo, latest bool, private bool) string {
+	if info.IsBurnable {
+		return "no-store"
+	}
+
+	maxAge := time.Until(info.Expire)
+	if latest {
+		maxAge = min(maxAge, maxAgeLatest)
+	}
+
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+
+	return fmt.Sprintf("%s, max-age=%d", scope, max(0, int(maxAge.Seconds())))
+}
+
+// isConditional tells whether the request may be answered with 304.
+func isConditional(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since if there is no
+// If-None-Match, as RFC 9110 section 13.2.2 orders them.
+func notModified(r *http.Request, info PasteInfo) bool {
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		tag := etag(info)
+		if tag == "" {
+			return false
+		}
+
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == tag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if info.Modified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !info.Modified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var modified = time.Date(2023, 11, 20, 12, 0, 0, 0, time.UTC)
+
+func TestGetConditional(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   PasteInfo
+		header map[string]string
+		want   int
+	}{
+		{name: "etag match", info: PasteInfo{Hash: "abc"}, header: map[string]string{"If-None-Match": `"abc"`}, want: http.StatusNotModified},
+		{name: "weak etag match", info: PasteInfo{Hash: "abc"}, header: map[string]string{"If-None-Match": `W/"abc"`}, want: http.StatusNotModified},
+		{name: "etag list", info: PasteInfo{Hash: "abc"}, header: map[string]string{"If-None-Match": `"old", "abc"`}, want: http.StatusNotModified},
+		{name: "any etag", info: PasteInfo{Hash: "abc"}, header: map[string]string{"If-None-Match": `*`}, want: http.StatusNotModified},
+		{name: "etag mismatch", info: PasteInfo{Hash: "abc"}, header: map[string]string{"If-None-Match": `"old"`}, want: http.StatusOK},
+		{name: "no hash", header: map[string]string{"If-None-Match": `"abc"`}, want: http.StatusOK},
+		{name: "not modified since", info: PasteInfo{Modified: modified}, header: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, want: http.StatusNotModified},
+		{name: "modified since", info: PasteInfo{Modified: modified}, header: map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)}, want: http.StatusOK},
+		{
+			name:   "etag wins over date",
+			info:   PasteInfo{Hash: "abc", Modified: modified},
+			header: map[string]string{"If-None-Match": `"old"`, "If-Modified-Since": modified.Format(http.TimeFormat)},
+			want:   http.StatusOK,
+		},
+		{name: "burnable", info: PasteInfo{Hash: "abc", IsBurnable: true, BurnAfter: 1}, header: map[string]string{"If-None-Match": `"abc"`}, want: http.StatusNotModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{info: tt.info, content: "lorem ipsum"}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/4Gp3gCWeXl", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			// Revalidation never takes a read from burnable pastes.
+			reads := 1
+			if tt.want == http.StatusNotModified {
+				reads = 0
+			}
+
+			if len(svc.queries) != reads {
+				t.Errorf("read %d times, want %d", len(svc.queries), reads)
+			}
+		})
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		accept       string
+		info         PasteInfo
+		etag         string
+		lastModified string
+		cache        string
+		maxAge       int
+	}{
+		{
+			name:         "latest",
+			target:       "/4Gp3gCWeXl",
+			info:         PasteInfo{Hash: "abc", Modified: modified},
+			etag:         `"abc"`,
+			lastModified: modified.Format(http.TimeFormat),
+			cache:        "public",
+			maxAge:       int(maxAgeLatest.Seconds()),
+		},
+		{name: "revision", target: "/4Gp3gCWeXl/rev/1", info: PasteInfo{Hash: "abc"}, etag: `"abc"`, cache: "public", maxAge: int((24 * time.Hour).Seconds())},
+		{name: "encoded", target: "/4Gp3gCWeXl", info: PasteInfo{Hash: "abc", Encoding: "zstd"}, etag: `"abc-zstd"`, cache: "public", maxAge: int(maxAgeLatest.Seconds())},
+		{name: "protected", target: "/4Gp3gCWeXl", info: PasteInfo{Hash: "abc", Protected: true}, etag: `"abc"`, cache: "private", maxAge: int(maxAgeLatest.Seconds())},
+		{name: "burnable", target: "/4Gp3gCWeXl", info: PasteInfo{Hash: "abc", IsBurnable: true, BurnAfter: 2}, etag: `"abc"`, cache: "no-store"},
+		{name: "expiring", target: "/4Gp3gCWeXl", info: PasteInfo{Expire: time.Now().Add(-time.Minute)}, cache: "public"},
+		{name: "page", target: "/4Gp3gCWeXl", accept: "text/html", info: PasteInfo{Hash: "abc", Modified: modified}, cache: "private", maxAge: int(maxAgeLatest.Seconds())},
+		{name: "burnable page", target: "/4Gp3gCWeXl", accept: "text/html", info: PasteInfo{Hash: "abc", IsBurnable: true, BurnAfter: 2}, cache: "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: &fakeService{info: tt.info, content: "lorem ipsum"},
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if got := w.Header().Get("Vary"); got != "Accept, Accept-Encoding" {
+				t.Errorf("Vary is %q, want Accept, Accept-Encoding", got)
+			}
+
+			if got := w.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag is %q, want %q", got, tt.etag)
+			}
+
+			if got := w.Header().Get("Last-Modified"); got != tt.lastModified {
+				t.Errorf("Last-Modified is %q, want %q", got, tt.lastModified)
+			}
+
+			scope, age, _ := strings.Cut(w.Header().Get("Cache-Control"), ", max-age=")
+			if scope != tt.cache {
+				t.Errorf("Cache-Control is %q, want %s", w.Header().Get("Cache-Control"), tt.cache)
+			}
+
+			if tt.cache == "no-store" {
+				return
+			}
+
+			// The max age counts down while the test runs.
+			if n, err := strconv.Atoi(age); err != nil || n > tt.maxAge || n < tt.maxAge-5 {
+				t.Errorf("max-age is %q, want %d", age, tt.maxAge)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultContentType = "text/plain; charset=utf-8"
+	binaryContentType  = "application/octet-stream"
+	maxFilenameLength  = 255
+)
+
+// DefaultInlineTypes are rendered by browsers without running scripts,
+// everything else is served as an attachment.
+var DefaultInlineTypes = []string{
+	"text/plain",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"audio/mpeg",
+	"audio/ogg",
+	"video/mp4",
+	"video/webm",
+}
+
+// ParseInlineTypes parses a comma separated list of MIME types, an empty
+// list yields DefaultInlineTypes.
+func ParseInlineTypes(s string) map[string]bool {
+	types := make(map[string]bool)
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			types[v] = true
+		}
+	}
+
+	if len(types) == 0 {
+		for _, v := range DefaultInlineTypes {
+			types[v] = true
+		}
+	}
+
+	return types
+}
+
+// parseContentType normalizes a client supplied type. Empty and generic
+// binary types yield "" so the service sniffs the content instead.
+func parseContentType(v string) (string, error) {
+	if v = strings.TrimSpace(v); v == "" {
+		return "", nil
+	}
+
+	mt, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return "", errBadValue
+	}
+
+	if mt == binaryContentType {
+		return "", nil
+	}
+
+	ct := mime.FormatMediaType(mt, params)
+	if ct == "" {
+		return "", errBadValue
+	}
+
+	return ct, nil
+}
+
+func validateFilename(name string) error {
+	if len(name) > maxFilenameLength || strings.ContainsAny(name, `/\`) {
+		return errBadValue
+	}
+
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return errBadValue
+		}
+	}
+
+	return nil
+}
+
+func mediaType(ct string) string {
+	mt, _, _ := strings.Cut(ct, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// acceptedEncodings returns the content codings listed in Accept-Encoding
+// with a non-zero quality. A wildcard is not honored, codings have to be
+// asked for by name.
+func acceptedEncodings(r *http.Request) []string {
+	var encodings []string
+
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+
+			if coding == "" || coding == "*" || coding == "identity" {
+				continue
+			}
+
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err != nil || v == 0 {
+					continue
+				}
+			}
+
+			encodings = append(encodings, coding)
+		}
+	}
+
+	return encodings
+}
+
+// isText tells whether the paste can be highlighted in the HTML view.
+func isText(ct string) bool {
+	mt := mediaType(ct)
+	return ct == "" || strings.HasPrefix(mt, "text/") || mt == "application/json"
+}
+
+// setContentHeaders describes the paste, the type is enforced with nosniff
+// so that only allow-listed types are ever rendered inline.
+func (s *Server) setContentHeaders(w http.ResponseWriter, info PasteInfo) {
+	ct := info.ContentType
+	if ct == "" {
+		ct = defaultContentType
+	}
+
+	if info.Encrypted {
+		ct = binaryContentType
+	}
+
+	disposition := "attachment"
+	if s.inlineTypes[mediaType(ct)] {
+		disposition = "inline"
+	}
+
+	if info.Filename != "" {
+		if v := mime.FormatMediaType(disposition, map[string]string{"filename": info.Filename}); v != "" {
+			disposition = v
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ct)
+	h.Set("Content-Disposition", disposition)
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	// The size of encoded content is not known up front.
+	if info.Encoding != "" {
+		h.Set("Content-Encoding", info.Encoding)
+	} else if info.Size > 0 {
+		h.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+
+	// Ranges are resolved against the stored size, burnable pastes are
+	// always served whole.
+	switch {
+	case info.IsBurnable:
+		h.Set("Accept-Ranges", "none")
+	case info.Size > 0:
+		h.Set("Accept-Ranges", "bytes")
+	}
+}
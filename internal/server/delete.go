@@ -0,0 +1,79 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+const deleteTokenHeader = "X-Delete-Token"
+
+// deleteToken returns the token sent in the header. Tokens are never taken
+// from the URL, which ends up in access logs and browser history.
+func deleteToken(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(deleteTokenHeader))
+}
+
+// bodyToken returns the token field of a urlencoded body, "" if there is
+// none. Go only parses the body of POST, PUT and PATCH forms by itself.
+func bodyToken(w http.ResponseWriter, r *http.Request) string {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/x-www-form-urlencoded" {
+		return ""
+	}
+
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFieldSize))
+	if err != nil {
+		return ""
+	}
+
+	v, err := url.ParseQuery(string(b))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(v.Get("token"))
+}
+
+func (s *Server) NewDelete(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		id = strings.TrimSpace(id)
+
+		token := deleteToken(r)
+		if token == "" {
+			token = bodyToken(w, r)
+		}
+
+		if id == "" || token == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		err := s.service.DeletePaste(r.Context(), id, token)
+		if err != nil {
+			if s.service.IsNoSuchPaste(err) {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
+
+			if s.service.IsInvalidToken(err) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			internalError(w)
+			logger.Error("Cannot delete paste", l.ErrorAttr(err))
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
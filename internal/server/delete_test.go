@@ -0,0 +1,60 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeleteToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		body   string
+		want   int
+	}{
+		{name: "header", target: "/4Gp3gCWeXl", header: "secret", want: http.StatusNoContent},
+		{name: "form body", target: "/4Gp3gCWeXl", body: "token=secret", want: http.StatusNoContent},
+		{name: "query", target: "/4Gp3gCWeXl?token=secret", want: http.StatusBadRequest},
+		{name: "no token", target: "/4Gp3gCWeXl", want: http.StatusBadRequest},
+		{name: "wrong token", target: "/4Gp3gCWeXl", header: "guess", want: http.StatusForbidden},
+		{name: "no paste", target: "/missing", header: "secret", want: http.StatusNotFound},
+		{name: "api header", target: "/api/v1/pastes/4Gp3gCWeXl", header: "secret", want: http.StatusNoContent},
+		{name: "api query", target: "/api/v1/pastes/4Gp3gCWeXl?token=secret", want: http.StatusBadRequest},
+		{name: "api wrong token", target: "/api/v1/pastes/4Gp3gCWeXl", header: "guess", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{token: "secret"}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodDelete, tt.target, strings.NewReader(tt.body))
+			if tt.header != "" {
+				r.Header.Set(deleteTokenHeader, tt.header)
+			}
+
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if deleted := len(svc.deleted) > 0; deleted != (tt.want == http.StatusNoContent) {
+				t.Errorf("deleted is %v, want %v", deleted, !deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetEncryptedRaw(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+	}{
+		{name: "raw", target: "/4Gp3gCWeXl"},
+		{name: "suffix", target: "/4Gp3gCWeXl.html"},
+		{name: "accept", target: "/4Gp3gCWeXl", accept: "text/html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: &fakeService{info: PasteInfo{ContentType: "text/plain", Encrypted: true}, content: "ciphertext"},
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if got := w.Header().Get("Content-Type"); got != binaryContentType {
+				t.Errorf("content type is %q, want %q", got, binaryContentType)
+			}
+
+			if got := w.Body.String(); got != "ciphertext" {
+				t.Errorf("body is %q, want the ciphertext as stored", got)
+			}
+		})
+	}
+}
+
+func TestCreateEncrypted(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		fields    []field
+		body      string
+		want      int
+		encrypted bool
+	}{
+		{
+			name:      "form",
+			target:    "/",
+			fields:    []field{{name: "encrypted", value: "true"}, {name: "content", value: "ciphertext"}},
+			want:      http.StatusOK,
+			encrypted: true,
+		},
+		{
+			name:   "form plain",
+			target: "/",
+			fields: []field{{name: "content", value: "lorem ipsum"}},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "form bad flag",
+			target: "/",
+			fields: []field{{name: "encrypted", value: "maybe"}, {name: "content", value: "ciphertext"}},
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:      "api",
+			target:    "/api/v1/pastes",
+			body:      `{"content": "ciphertext", "encrypted": true}`,
+			want:      http.StatusCreated,
+			encrypted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+				MaxSize: 1024,
+			})
+
+			var r *http.Request
+
+			if tt.fields != nil {
+				body, ct := multipartBody(t, tt.fields)
+				r = httptest.NewRequest(http.MethodPost, tt.target, body)
+				r.Header.Set("Content-Type", ct)
+			} else {
+				r = httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if len(svc.created) == 0 {
+				if tt.want < 300 {
+					t.Fatal("no paste created")
+				}
+
+				return
+			}
+
+			if got := svc.created[0].Encrypted; got != tt.encrypted {
+				t.Errorf("encrypted is %v, want %v", got, tt.encrypted)
+			}
+		})
+	}
+}
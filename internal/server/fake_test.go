@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	errNoPaste      = errors.New("no such paste")
+	errBadToken     = errors.New("invalid token")
+	errPassword     = errors.New("wrong password")
+	errAttempts     = errors.New("too many attempts")
+	errUnauthorized = errors.New("unauthorized")
+	errKeyStore     = errors.New("repo down")
+	errRange        = errors.New("range not satisfiable")
+	errCorrupt      = errors.New("checksum mismatch")
+)
+
+// fakeUsers are the users of the keys fakeService knows. The "broken" key
+// fails to authenticate.
+var fakeUsers = map[string]User{
+	"alice-key": {ID: 1, Name: "alice"},
+	"bob-key":   {ID: 2, Name: "bob"},
+	"admin-key": {ID: 3, Name: "root", Admin: true},
+}
+
+// fakeService is the Service of the handler tests. Every paste but
+// "missing" exists, is described by info and serves content, ranges of it
+// like the service does. What the handlers asked for is recorded.
+type fakeService struct {
+	info     PasteInfo /* the expiry defaults to a day from now */
+	content  string
+	corrupt  bool                  /* content fails its checksum at the end */
+	password string                /* protects the pastes unless empty */
+	attempts int                   /* wrong passwords taken before every one is refused */
+	token    string                /* updates and deletes the pastes */
+	owned    map[int64][]PasteInfo /* pastes listed by user id */
+	found    []PasteInfo           /* pastes admins find and force delete */
+	blocked  map[string]string
+	stats    BlobStats
+
+	queries []Query /* of every paste read */
+	created []Paste
+	uploads []string /* content of created pastes */
+	updates []string /* content of updates, each one a revision */
+	deleted []string
+	filters []PasteFilter
+	listed  []int /* limit and offset of every listing */
+}
+
+func (s *fakeService) CreatePaste(_ context.Context, p Paste) (PasteInfo, error) {
+	content, err := io.ReadAll(p.Content)
+	if err != nil {
+		return PasteInfo{}, err
+	}
+
+	s.created = append(s.created, p)
+	s.uploads = append(s.uploads, string(content))
+
+	return PasteInfo{ID: "4Gp3gCWeXl", DeleteToken: "token", Expire: time.Now().Add(time.Hour)}, nil
+}
+
+func (s *fakeService) StatPaste(_ context.Context, q Query) (PasteInfo, error) {
+	if q.ID == "missing" {
+		return PasteInfo{}, errNoPaste
+	}
+
+	if s.password != "" && !q.Authorized {
+		if s.attempts <= 0 {
+			return PasteInfo{}, errAttempts
+		}
+
+		if q.Password != s.password {
+			s.attempts--
+			return PasteInfo{}, errPassword
+		}
+	}
+
+	info := s.info
+	info.ID = q.ID
+
+	if q.Revision > 0 {
+		info.Revision = q.Revision
+	}
+
+	if info.Expire.IsZero() {
+		info.Expire = time.Now().Add(24 * time.Hour)
+	}
+
+	return info, nil
+}
+
+func (s *fakeService) GetPaste(ctx context.Context, q Query) (PasteInfo, io.ReadCloser, error) {
+	info, err := s.StatPaste(ctx, q)
+	if err != nil {
+		return info, nil, err
+	}
+
+	content := s.content
+
+	if q.Range != nil {
+		offset, length, ok := q.Range.Resolve(info.Size)
+		if !ok {
+			return info, nil, errRange
+		}
+
+		info.Partial, info.Offset, info.Length = true, offset, length
+		content = content[offset : offset+length]
+	}
+
+	s.queries = append(s.queries, q)
+
+	var body io.Reader = strings.NewReader(content)
+	if s.corrupt {
+		body = io.MultiReader(body, errReader{errCorrupt})
+	}
+
+	return info, io.NopCloser(body), nil
+}
+
+func (s *fakeService) UpdatePaste(_ context.Context, id string, token string, content io.Reader, _ int64) (PasteInfo, error) {
+	if id == "missing" {
+		return PasteInfo{}, errNoPaste
+	}
+
+	if token != s.token {
+		return PasteInfo{}, errBadToken
+	}
+
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return PasteInfo{}, err
+	}
+
+	s.updates = append(s.updates, string(b))
+
+	return PasteInfo{ID: id, Revision: len(s.updates) + 1, Expire: time.Now().Add(time.Hour)}, nil
+}
+
+func (s *fakeService) DeletePaste(_ context.Context, id string, token string) error {
+	if id == "missing" {
+		return errNoPaste
+	}
+
+	if token != s.token {
+		return errBadToken
+	}
+
+	s.deleted = append(s.deleted, id)
+
+	return nil
+}
+
+func (s *fakeService) Authenticate(_ context.Context, key string) (User, error) {
+	if key == "broken" {
+		return User{}, errKeyStore
+	}
+
+	u, ok := fakeUsers[key]
+	if !ok {
+		return User{}, errUnauthorized
+	}
+
+	return u, nil
+}
+
+func (s *fakeService) ListPastes(_ context.Context, user User, limit, offset int) ([]PasteInfo, error) {
+	s.listed = append(s.listed, limit, offset)
+	return s.owned[user.ID], nil
+}
+
+func (s *fakeService) FindPastes(_ context.Context, f PasteFilter) ([]PasteInfo, error) {
+	s.filters = append(s.filters, f)
+
+	var found []PasteInfo
+
+	for _, info := range s.found {
+		if (f.ID == "" || f.ID == info.ID) && (f.Hash == "" || f.Hash == info.Hash) {
+			found = append(found, info)
+		}
+	}
+
+	return found, nil
+}
+
+func (s *fakeService) ForceDeletePaste(_ context.Context, id string) error {
+	for i, info := range s.found {
+		if info.ID == id {
+			s.deleted = append(s.deleted, id)
+			s.found = append(s.found[:i:i], s.found[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return errNoPaste
+}
+
+func (s *fakeService) BlockHash(_ context.Context, hash string, reason string) error {
+	if s.blocked == nil {
+		s.blocked = make(map[string]string)
+	}
+
+	s.blocked[hash] = reason
+
+	return nil
+}
+
+func (s *fakeService) UnblockHash(_ context.Context, hash string) error {
+	if _, ok := s.blocked[hash]; !ok {
+		return errNoPaste
+	}
+
+	delete(s.blocked, hash)
+
+	return nil
+}
+
+func (s *fakeService) BlobStats(context.Context) (BlobStats, error) {
+	return s.stats, nil
+}
+
+func (s *fakeService) IsNoSuchPaste(err error) bool { return errors.Is(err, errNoPaste) }
+
+func (s *fakeService) IsInvalidToken(err error) bool { return errors.Is(err, errBadToken) }
+
+func (s *fakeService) IsWrongPassword(err error) bool { return errors.Is(err, errPassword) }
+
+func (s *fakeService) IsTooManyAttempts(err error) bool { return errors.Is(err, errAttempts) }
+
+func (s *fakeService) IsUnauthorized(err error) bool { return errors.Is(err, errUnauthorized) }
+
+func (s *fakeService) IsRangeNotSatisfiable(err error) bool { return errors.Is(err, errRange) }
+
+func (s *fakeService) IsChecksumMismatch(err error) bool { return errors.Is(err, errCorrupt) }
+
+func (s *fakeService) IsBlocked(error) bool { return false }
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
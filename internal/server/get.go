@@ -1,25 +1,140 @@
 package server
 
 import (
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	l "github.com/swmh/gopetbin/internal/logger"
 )
 
+const passwordHeader = "X-Paste-Password"
+
+type Query struct {
+	ID       string
+	Revision int /* 0 for the latest revision */
+	Password string
+	Range    *ByteRange /* nil for the whole paste */
+
+	Encodings []string /* content codings the client accepts */
+
+	// Authorized skips the password check, set once StatPaste accepted the
+	// password for the same request.
+	Authorized bool
+}
+
+// pastePassword returns the password from the X-Paste-Password header or
+// from HTTP Basic auth, the username is ignored.
+func pastePassword(r *http.Request) string {
+	if password := r.Header.Get(passwordHeader); password != "" {
+		return password
+	}
+
+	_, password, _ := r.BasicAuth()
+
+	return password
+}
+
+func parseQuery(r *http.Request) (Query, error) {
+	id := chi.URLParam(r, "id")
+	id = strings.TrimSpace(id)
+	id = strings.TrimSuffix(id, htmlSuffix)
+	if id == "" {
+		return Query{}, errBadValue
+	}
+
+	q := Query{ID: id, Password: pastePassword(r)}
+
+	if v := strings.TrimSuffix(chi.URLParam(r, "rev"), htmlSuffix); v != "" {
+		rev, err := strconv.Atoi(v)
+		if err != nil || rev <= 0 {
+			return Query{}, errBadValue
+		}
+
+		q.Revision = rev
+	}
+
+	return q, nil
+}
+
+// writeGetError answers a failed read of a paste.
+func (s *Server) writeGetError(w http.ResponseWriter, err error) {
+	if s.service.IsNoSuchPaste(err) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	if s.service.IsWrongPassword(err) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gopetbin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	if s.service.IsTooManyAttempts(err) {
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
+	internalError(w)
+	s.logger.Error("Cannot get file", l.ErrorAttr(err))
+}
+
+// rendersHTML tells whether the paste is served as highlighted HTML. The
+// server cannot highlight ciphertext, encrypted pastes are always served
+// raw and decrypted by the client.
+func rendersHTML(r *http.Request, info PasteInfo) bool {
+	return wantsHTML(r) && !info.Encrypted && isText(info.ContentType)
+}
+
 func (s *Server) NewGet(logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := chi.URLParam(r, "id")
-		id = strings.TrimSpace(id)
-		if id == "" {
+		q, err := parseQuery(r)
+		if err != nil {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
 
-		file, err := s.service.GetPaste(r.Context(), id)
+		latest := q.Revision == 0
+
+		// Highlighting needs the plain content, so only raw responses may
+		// be partial or encoded.
+		if !wantsHTML(r) {
+			if br, ok := parseRange(r.Header.Get("Range")); ok {
+				q.Range = &br
+			}
+
+			q.Encodings = acceptedEncodings(r)
+		}
+
+		// Revalidation is answered from the metadata alone, so it never
+		// takes a read from burnable pastes.
+		if isConditional(r) || (q.Range != nil && r.Header.Get("If-Range") != "") {
+			info, err := s.service.StatPaste(r.Context(), q)
+			if err != nil {
+				s.writeGetError(w, err)
+				return
+			}
+
+			if isConditional(r) && !rendersHTML(r, info) && notModified(r, info) {
+				setCacheHeaders(w, info, latest)
+				w.WriteHeader(http.StatusNotModified)
+
+				return
+			}
+
+			if !ifRangeMatches(r, info) {
+				q.Range = nil
+			}
+
+			q.Authorized = true
+		}
+
+		info, file, err := s.service.GetPaste(r.Context(), q)
 		defer func() {
 			if file != nil {
 				file.Close()
@@ -27,17 +142,50 @@ func (s *Server) NewGet(logger *slog.Logger) http.HandlerFunc {
 		}()
 
 		if err != nil {
-			if s.service.IsNoSuchPaste(err) {
-				http.Error(w, "Not Found", http.StatusNotFound)
+			if s.service.IsRangeNotSatisfiable(err) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+				http.Error(w, "Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+
 				return
 			}
 
-			internalError(w)
-			s.logger.Error("Cannot get file", l.ErrorAttr(err))
+			s.writeGetError(w, err)
 
 			return
 		}
 
-		io.Copy(w, file)
+		if rendersHTML(r, info) {
+			data, err := io.ReadAll(file)
+			if err != nil {
+				internalError(w)
+				s.logger.Error("Cannot read file", l.ErrorAttr(err))
+
+				return
+			}
+
+			setPageCacheHeaders(w, info, latest)
+
+			if err = renderHTML(w, q.ID, info.Lang, string(data)); err != nil {
+				internalError(w)
+				s.logger.Error("Cannot render paste", l.ErrorAttr(err))
+			}
+
+			return
+		}
+
+		setCacheHeaders(w, info, latest)
+		s.setContentHeaders(w, info)
+
+		if info.Partial {
+			w.Header().Set("Content-Range", contentRange(info))
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Length, 10))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+
+		// A mismatch is only known once the content has been sent, the
+		// connection is dropped so the response does not look complete.
+		if _, err = io.Copy(w, file); err != nil && s.service.IsChecksumMismatch(err) {
+			panic(http.ErrAbortHandler)
+		}
 	}
 }
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetCorruptAborts(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+	}{
+		{name: "chunked"},
+		{name: "content length", size: int64(len("garbage"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: &fakeService{info: PasteInfo{Size: tt.size, ContentType: "text/plain"}, content: "garbage", corrupt: true},
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			ts := httptest.NewServer(srv.server.Handler)
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL + "/4Gp3gCWeXl")
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			if _, err = io.ReadAll(resp.Body); err == nil {
+				t.Fatal("corrupt content was served as a complete response")
+			}
+		})
+	}
+}
+
+func TestGetConditionalAuthorized(t *testing.T) {
+	svc := &fakeService{info: PasteInfo{Hash: "hash", ContentType: "text/plain"}, content: "content"}
+	srv := New(Config{
+		Service: svc,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/4Gp3gCWeXl", nil)
+	r.Header.Set("If-None-Match", `"stale"`)
+	r.Header.Set(passwordHeader, "secret")
+
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if len(svc.queries) != 1 || !svc.queries[0].Authorized {
+		t.Errorf("GetPaste queries are %+v, want one authorized by StatPaste", svc.queries)
+	}
+}
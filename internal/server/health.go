@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+const defaultCheckTimeout = 2 * time.Second
+
+// Pinger is a dependency checked by /readyz.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type checkStatus struct {
+	Status string `json:"status"`
+}
+
+type readyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkStatus `json:"checks,omitempty"`
+}
+
+// NewHealth reports that the process is up, it never touches the backends.
+func (s *Server) NewHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, readyResponse{Status: "ok"})
+	}
+}
+
+// NewReady pings every dependency concurrently, each with its own timeout,
+// and answers 503 if any of them failed. The endpoint is public: it only
+// tells ok from fail, errors are logged, and an answer is reused for the
+// check timeout so requests cannot pile pings on the backends.
+func (s *Server) NewReady(logger *slog.Logger) http.HandlerFunc {
+	var (
+		mu      sync.Mutex
+		last    readyResponse
+		checked time.Time
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if time.Since(checked) >= s.checkTimeout {
+			last = s.ready(context.WithoutCancel(r.Context()), logger)
+			checked = time.Now()
+		}
+
+		resp := last
+		mu.Unlock()
+
+		code := http.StatusOK
+		if resp.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, code, resp)
+	}
+}
+
+func (s *Server) ready(ctx context.Context, logger *slog.Logger) readyResponse {
+	resp := readyResponse{
+		Status: "ok",
+		Checks: make(map[string]checkStatus, len(s.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, p := range s.checks {
+		wg.Add(1)
+
+		go func(name string, p Pinger) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, s.checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := p.Ping(ctx)
+
+			status := checkStatus{Status: "ok"}
+
+			if err != nil {
+				logger.Warn("Dependency check failed", slog.String("check", name),
+					slog.Duration("latency", time.Since(start)), l.ErrorAttr(err))
+
+				status.Status = "fail"
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			resp.Checks[name] = status
+			if err != nil {
+				resp.Status = "fail"
+			}
+		}(name, p)
+	}
+
+	wg.Wait()
+
+	return resp
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pinger fails every ping with err and counts them.
+type pinger struct {
+	err   error
+	pings atomic.Int32
+}
+
+func (p *pinger) Ping(context.Context) error {
+	p.pings.Add(1)
+	return p.err
+}
+
+func TestReadyHidesErrors(t *testing.T) {
+	db := &pinger{err: errors.New("dial tcp 10.0.0.5:5432: connection refused")}
+
+	srv := New(Config{
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Checks:       map[string]Pinger{"db": db, "cache": &pinger{}},
+		CheckTimeout: time.Hour,
+	})
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("status is %d, want %d", w.Code, http.StatusServiceUnavailable)
+		}
+
+		if body := w.Body.String(); strings.Contains(body, "10.0.0.5") {
+			t.Errorf("body %s reveals the error", body)
+		}
+	}
+
+	if n := db.pings.Load(); n != 1 {
+		t.Errorf("db pinged %d times, want 1", n)
+	}
+}
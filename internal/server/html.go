@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+const (
+	htmlSuffix     = ".html"
+	highlightStyle = "github"
+)
+
+var htmlPage = template.Must(template.New("paste").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; }
+.chroma a { color: inherit; text-decoration: none; }
+{{.CSS}}
+</style>
+</head>
+<body>
+{{.Code}}
+<script>
+(function () {
+	function highlight() {
+		document.querySelectorAll(".chroma .hl").forEach(function (el) {
+			el.classList.remove("hl");
+		});
+
+		var m = location.hash.match(/^#L(\d+)(?:-L(\d+))?$/);
+		if (!m) {
+			return;
+		}
+
+		var from = parseInt(m[1], 10), to = parseInt(m[2] || m[1], 10);
+		if (from > to) {
+			var t = from; from = to; to = t;
+		}
+
+		for (var i = from; i <= to; i++) {
+			var el = document.getElementById("L" + i);
+			if (el) {
+				el.parentElement.classList.add("hl");
+			}
+		}
+
+		var first = document.getElementById("L" + from);
+		if (first) {
+			first.scrollIntoView({block: "center"});
+		}
+	}
+
+	document.addEventListener("click", function (e) {
+		var a = e.target.closest(".chroma .ln a");
+		if (!a || !e.shiftKey) {
+			return;
+		}
+
+		var m = location.hash.match(/^#L(\d+)/);
+		if (m) {
+			e.preventDefault();
+			location.hash = "#L" + m[1] + "-" + a.getAttribute("href").slice(1);
+		}
+	});
+
+	window.addEventListener("hashchange", highlight);
+	highlight();
+})();
+</script>
+</body>
+</html>
+`))
+
+type htmlPageData struct {
+	Title string
+	CSS   template.CSS
+	Code  template.HTML
+}
+
+// isValidLang reports whether lang is a language known to the highlighter.
+func isValidLang(lang string) bool {
+	return lexers.Get(lang) != nil
+}
+
+// wantsHTML reports whether the paste should be rendered as an HTML page
+// rather than served raw.
+func wantsHTML(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, htmlSuffix) {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func getLexer(lang, content string) chroma.Lexer {
+	var lexer chroma.Lexer
+
+	if lang != "" {
+		lexer = lexers.Get(lang)
+	}
+
+	if lexer == nil {
+		lexer = lexers.Analyse(content)
+	}
+
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	return chroma.Coalesce(lexer)
+}
+
+func renderHTML(w http.ResponseWriter, title, lang, content string) error {
+	style := styles.Get(highlightStyle)
+	formatter := html.New(
+		html.WithClasses(true),
+		html.WithLineNumbers(true),
+		html.WithLinkableLineNumbers(true, "L"),
+	)
+
+	iterator, err := getLexer(lang, content).Tokenise(nil, content)
+	if err != nil {
+		return err
+	}
+
+	var css, code bytes.Buffer
+
+	if err = formatter.WriteCSS(&css, style); err != nil {
+		return err
+	}
+
+	if err = formatter.Format(&code, style, iterator); err != nil {
+		return err
+	}
+
+	var page bytes.Buffer
+
+	err = htmlPage.Execute(&page, htmlPageData{
+		Title: title,
+		CSS:   template.CSS(css.String()),
+		Code:  template.HTML(code.String()),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = page.WriteTo(w)
+
+	return err
+}
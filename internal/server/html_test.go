@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		info   PasteInfo
+		want   string
+		html   bool
+	}{
+		{name: "suffix", target: "/4Gp3gCWeXl.html", info: PasteInfo{ContentType: "text/plain", Lang: "go"}, want: "text/html", html: true},
+		{name: "accept", target: "/4Gp3gCWeXl", accept: "text/html", info: PasteInfo{ContentType: "text/plain"}, want: "text/html", html: true},
+		{name: "revision", target: "/4Gp3gCWeXl/rev/1.html", info: PasteInfo{ContentType: "text/plain"}, want: "text/html", html: true},
+		{name: "raw", target: "/4Gp3gCWeXl", info: PasteInfo{ContentType: "text/plain"}, want: "text/plain"},
+		{name: "binary", target: "/4Gp3gCWeXl.html", info: PasteInfo{ContentType: "image/png"}, want: "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: &fakeService{info: tt.info, content: "package main"},
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+			}
+
+			if got := mediaType(w.Header().Get("Content-Type")); got != tt.want {
+				t.Errorf("content type is %q, want %q", got, tt.want)
+			}
+
+			if got := strings.Contains(w.Body.String(), "<html"); got != tt.html {
+				t.Errorf("body is html %v, want %v", got, tt.html)
+			}
+		})
+	}
+}
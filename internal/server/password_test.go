@@ -0,0 +1,86 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// lockedPaste is the info of a paste protected by a password.
+var lockedPaste = PasteInfo{Hash: "hash", Protected: true, ContentType: "text/plain"}
+
+func TestGetPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		header   string
+		basic    string
+		attempts int
+		want     int
+		auth     string
+	}{
+		{name: "header", target: "/4Gp3gCWeXl", header: "secret", attempts: 3, want: http.StatusOK},
+		{name: "basic auth", target: "/4Gp3gCWeXl", basic: "secret", attempts: 3, want: http.StatusOK},
+		{name: "no password", target: "/4Gp3gCWeXl", attempts: 3, want: http.StatusUnauthorized, auth: `Basic realm="gopetbin"`},
+		{name: "wrong password", target: "/4Gp3gCWeXl", header: "guess", attempts: 3, want: http.StatusUnauthorized, auth: `Basic realm="gopetbin"`},
+		{name: "too many attempts", target: "/4Gp3gCWeXl", header: "secret", want: http.StatusTooManyRequests},
+		{name: "api", target: "/api/v1/pastes/4Gp3gCWeXl", header: "secret", attempts: 3, want: http.StatusOK},
+		{name: "api wrong password", target: "/api/v1/pastes/4Gp3gCWeXl", header: "guess", attempts: 3, want: http.StatusUnauthorized},
+		{name: "api too many attempts", target: "/api/v1/pastes/4Gp3gCWeXl", header: "secret", want: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{info: lockedPaste, content: "lorem ipsum", password: "secret", attempts: tt.attempts}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set(passwordHeader, tt.header)
+			}
+
+			if tt.basic != "" {
+				r.SetBasicAuth("anyone", tt.basic)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.auth {
+				t.Errorf("WWW-Authenticate is %q, want %q", got, tt.auth)
+			}
+
+			if (len(svc.queries) == 1) != (tt.want == http.StatusOK) {
+				t.Errorf("read %d times", len(svc.queries))
+			}
+		})
+	}
+}
+
+// Protected pastes must not end up in shared caches.
+func TestGetPasswordPrivate(t *testing.T) {
+	srv := New(Config{
+		Service: &fakeService{info: lockedPaste, content: "lorem ipsum", password: "secret", attempts: 1},
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/4Gp3gCWeXl", nil)
+	r.Header.Set(passwordHeader, "secret")
+
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+		t.Errorf("Cache-Control is %q, want private", got)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteRange is a single range of a Range header. End is inclusive and -1
+// for an open range, a Start of -1 asks for the last End bytes.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Resolve returns the offset and length of the range in content of size
+// bytes, ok is false if the range does not overlap the content.
+func (b ByteRange) Resolve(size int64) (int64, int64, bool) {
+	if b.Start == -1 {
+		if b.End <= 0 || size <= 0 {
+			return 0, 0, false
+		}
+
+		n := min(b.End, size)
+
+		return size - n, n, true
+	}
+
+	if b.Start >= size {
+		return 0, 0, false
+	}
+
+	end := b.End
+	if end == -1 || end >= size {
+		end = size - 1
+	}
+
+	return b.Start, end - b.Start + 1, true
+}
+
+// parseRange parses a Range header with a single byte range. Anything else,
+// including multiple ranges, is ignored and the whole paste is served.
+func parseRange(v string) (ByteRange, bool) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(v), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return ByteRange{}, false
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return ByteRange{}, false
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return ByteRange{}, false
+		}
+
+		return ByteRange{Start: -1, End: n}, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return ByteRange{}, false
+	}
+
+	if last == "" {
+		return ByteRange{Start: start, End: -1}, true
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return ByteRange{}, false
+	}
+
+	return ByteRange{Start: start, End: end}, true
+}
+
+// ifRangeMatches evaluates If-Range, a range is only served if the client
+// still has the same content. ETags are compared strongly, dates exactly.
+func ifRangeMatches(r *http.Request, info PasteInfo) bool {
+	v := strings.TrimSpace(r.Header.Get("If-Range"))
+	if v == "" {
+		return true
+	}
+
+	if strings.HasPrefix(v, `"`) {
+		return v == etag(info)
+	}
+
+	if strings.HasPrefix(v, "W/") || info.Modified.IsZero() {
+		return false
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return false
+	}
+
+	return info.Modified.Truncate(time.Second).Equal(t)
+}
+
+func contentRange(info PasteInfo) string {
+	return fmt.Sprintf("bytes %d-%d/%d", info.Offset, info.Offset+info.Length-1, info.Size)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetRange(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		header       map[string]string
+		want         int
+		body         string
+		contentRange string
+	}{
+		{name: "whole", target: "/4Gp3gCWeXl", want: http.StatusOK, body: "0123456789"},
+		{name: "first bytes", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=0-3"}, want: http.StatusPartialContent, body: "0123", contentRange: "bytes 0-3/10"},
+		{name: "open range", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=5-"}, want: http.StatusPartialContent, body: "56789", contentRange: "bytes 5-9/10"},
+		{name: "suffix", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=-3"}, want: http.StatusPartialContent, body: "789", contentRange: "bytes 7-9/10"},
+		{name: "past the end", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=8-20"}, want: http.StatusPartialContent, body: "89", contentRange: "bytes 8-9/10"},
+		{name: "not satisfiable", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=20-"}, want: http.StatusRequestedRangeNotSatisfiable, contentRange: "bytes */10"},
+		{name: "multiple ranges", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=0-1,4-5"}, want: http.StatusOK, body: "0123456789"},
+		{name: "if-range etag", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=0-3", "If-Range": `"abc"`}, want: http.StatusPartialContent, body: "0123", contentRange: "bytes 0-3/10"},
+		{name: "if-range date", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=0-3", "If-Range": modified.Format(http.TimeFormat)}, want: http.StatusPartialContent, body: "0123", contentRange: "bytes 0-3/10"},
+		{name: "if-range stale", target: "/4Gp3gCWeXl", header: map[string]string{"Range": "bytes=0-3", "If-Range": `"old"`}, want: http.StatusOK, body: "0123456789"},
+		{name: "html", target: "/4Gp3gCWeXl.html", header: map[string]string{"Range": "bytes=0-3"}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(Config{
+				Service: &fakeService{
+					info:    PasteInfo{Hash: "abc", Size: 10, Modified: modified, ContentType: "text/plain", Expire: time.Now().Add(time.Hour)},
+					content: "0123456789",
+				},
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range is %q, want %q", got, tt.contentRange)
+			}
+
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body is %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		v    string
+		want ByteRange
+		ok   bool
+	}{
+		{v: "bytes=0-99", want: ByteRange{Start: 0, End: 99}, ok: true},
+		{v: "bytes=100-", want: ByteRange{Start: 100, End: -1}, ok: true},
+		{v: "bytes=-50", want: ByteRange{Start: -1, End: 50}, ok: true},
+		{v: " bytes= 1-2 ", want: ByteRange{Start: 1, End: 2}, ok: true},
+		{v: "bytes=5-1"},
+		{v: "bytes=0-1,3-4"},
+		{v: "bytes=a-b"},
+		{v: "bytes=-"},
+		{v: "items=0-1"},
+		{v: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			got, ok := parseRange(tt.v)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseRange(%q) is %+v, %v, want %+v, %v", tt.v, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		r              ByteRange
+		size           int64
+		offset, length int64
+		ok             bool
+	}{
+		{name: "inside", r: ByteRange{Start: 2, End: 4}, size: 10, offset: 2, length: 3, ok: true},
+		{name: "open", r: ByteRange{Start: 2, End: -1}, size: 10, offset: 2, length: 8, ok: true},
+		{name: "clipped", r: ByteRange{Start: 8, End: 99}, size: 10, offset: 8, length: 2, ok: true},
+		{name: "suffix", r: ByteRange{Start: -1, End: 3}, size: 10, offset: 7, length: 3, ok: true},
+		{name: "suffix larger than content", r: ByteRange{Start: -1, End: 30}, size: 10, offset: 0, length: 10, ok: true},
+		{name: "empty suffix", r: ByteRange{Start: -1, End: 0}, size: 10},
+		{name: "past the end", r: ByteRange{Start: 10, End: -1}, size: 10},
+		{name: "empty content", r: ByteRange{Start: -1, End: 5}, size: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offset, length, ok := tt.r.Resolve(tt.size)
+			if ok != tt.ok || (ok && (offset != tt.offset || length != tt.length)) {
+				t.Errorf("resolved to %d+%d, %v, want %d+%d, %v", offset, length, ok, tt.offset, tt.length, tt.ok)
+			}
+		})
+	}
+}
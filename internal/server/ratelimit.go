@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+// Limiter decides whether the client identified by key may make another
+// request, and if not, how long it should wait.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// ParseTrustedProxies parses a comma separated list of IPs and CIDRs.
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("bad trusted proxy %q: %w", v, err)
+			}
+
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %q: %w", v, err)
+		}
+
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, p := range s.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP is the peer address, unless the peer is a trusted proxy. Then
+// X-Forwarded-For is walked from the right, skipping trusted proxies, and
+// the first untrusted hop is the client, entries left of it could have
+// been forged by the client itself.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !s.isTrustedProxy(addr) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		addr = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return addr.Unmap().String()
+}
+
+// limit rejects requests over the limit with 429. If the limiter itself
+// fails the request is let through, a broken limiter should not take the
+// whole service down.
+func (s *Server) limit(limiter Limiter, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := limiter.Allow(r.Context(), s.clientIP(r))
+			if err != nil {
+				logger.Error("Cannot check rate limit", l.ErrorAttr(err))
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			if !ok {
+				seconds := int(math.Max(1, math.Ceil(retryAfter.Seconds())))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// keyLimiter allows each key allow requests, failing with err if set.
+type keyLimiter struct {
+	allow int
+	wait  time.Duration
+	err   error
+	keys  map[string]int
+}
+
+func (l *keyLimiter) Allow(_ context.Context, key string) (bool, time.Duration, error) {
+	if l.err != nil {
+		return false, 0, l.err
+	}
+
+	l.keys[key]++
+
+	return l.keys[key] <= l.allow, l.wait, nil
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		limiter    *keyLimiter
+		requests   int
+		want       int
+		retryAfter string
+	}{
+		{name: "under limit", limiter: &keyLimiter{allow: 2}, requests: 2, want: http.StatusOK},
+		{name: "over limit", limiter: &keyLimiter{allow: 2, wait: 1500 * time.Millisecond}, requests: 3, want: http.StatusTooManyRequests, retryAfter: "2"},
+		{name: "short wait", limiter: &keyLimiter{allow: 0, wait: time.Millisecond}, requests: 1, want: http.StatusTooManyRequests, retryAfter: "1"},
+		{name: "limiter down", limiter: &keyLimiter{err: errors.New("redis down")}, requests: 3, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.limiter.keys = make(map[string]int)
+
+			srv := New(Config{
+				Service:     &fakeService{info: PasteInfo{ContentType: "text/plain"}, content: "lorem ipsum"},
+				Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+				ReadLimiter: tt.limiter,
+			})
+
+			var w *httptest.ResponseRecorder
+
+			for i := 0; i < tt.requests; i++ {
+				w = httptest.NewRecorder()
+				srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/4Gp3gCWeXl", nil))
+			}
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After is %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
+
+// Reads and creates are limited apart, a client out of uploads may still
+// read.
+func TestLimitRoutes(t *testing.T) {
+	create := &keyLimiter{keys: make(map[string]int)}
+	read := &keyLimiter{allow: 1, keys: make(map[string]int)}
+
+	srv := New(Config{
+		Service:       &fakeService{info: PasteInfo{ContentType: "text/plain"}, content: "lorem ipsum"},
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		CreateLimiter: create,
+		ReadLimiter:   read,
+	})
+
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/pastes", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("create status is %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	w = httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/pastes/4Gp3gCWeXl", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("read status is %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.1, 192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		forward []string
+		want    string
+	}{
+		{name: "direct", remote: "203.0.113.7:4242", want: "203.0.113.7"},
+		{name: "untrusted forward", remote: "203.0.113.7:4242", forward: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.1:4242", forward: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy chain", remote: "10.0.0.1:4242", forward: []string{"198.51.100.1, 192.168.1.1"}, want: "198.51.100.1"},
+		{name: "forged entry", remote: "10.0.0.1:4242", forward: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "split headers", remote: "10.0.0.1:4242", forward: []string{"198.51.100.1", "192.168.1.1"}, want: "198.51.100.1"},
+		{name: "garbage", remote: "10.0.0.1:4242", forward: []string{"not an ip"}, want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{trustedProxies: proxies}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+
+			for _, v := range tt.forward {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("client ip is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name string
+		v    string
+		want []netip.Prefix
+		err  bool
+	}{
+		{name: "empty", v: ""},
+		{name: "ip", v: "10.0.0.1", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}},
+		{name: "cidr", v: "10.0.0.7/8", want: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		{name: "bad", v: "10.0.0.1, proxy", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTrustedProxies(tt.v)
+			if (err != nil) != tt.err {
+				t.Fatalf("error is %v, want error %v", err, tt.err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("proxies are %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("proxies are %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
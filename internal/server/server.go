@@ -2,42 +2,96 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/swmh/gopetbin/internal/server")
+
 func internalError(w http.ResponseWriter) {
 	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
 
 type Service interface {
-	CreatePaste(ctx context.Context, paste Paste) (string, error)
-	GetPaste(ctx context.Context, id string) (io.ReadCloser, error)
+	CreatePaste(ctx context.Context, paste Paste) (PasteInfo, error)
+	GetPaste(ctx context.Context, q Query) (PasteInfo, io.ReadCloser, error)
+	StatPaste(ctx context.Context, q Query) (PasteInfo, error)
+	UpdatePaste(ctx context.Context, id string, token string, content io.Reader, size int64) (PasteInfo, error)
+	DeletePaste(ctx context.Context, id string, token string) error
+	Authenticate(ctx context.Context, key string) (User, error)
+	ListPastes(ctx context.Context, user User, limit, offset int) ([]PasteInfo, error)
+	FindPastes(ctx context.Context, f PasteFilter) ([]PasteInfo, error)
+	ForceDeletePaste(ctx context.Context, id string) error
+	BlockHash(ctx context.Context, hash string, reason string) error
+	UnblockHash(ctx context.Context, hash string) error
+	BlobStats(ctx context.Context) (BlobStats, error)
 	IsNoSuchPaste(error) bool
+	IsInvalidToken(error) bool
+	IsWrongPassword(error) bool
+	IsTooManyAttempts(error) bool
+	IsUnauthorized(error) bool
+	IsRangeNotSatisfiable(error) bool
+	IsChecksumMismatch(error) bool
+	IsBlocked(error) bool
+}
+
+// Observer is notified about every served request, route is the matched
+// chi pattern so paste ids do not blow up label cardinality.
+type Observer interface {
+	ObserveRequest(method, route string, code int, d time.Duration)
 }
 
 type Config struct {
-	Service       Service
-	Logger        *slog.Logger
-	PublicPath    string
-	Addr          string
-	MaxSize       int64
-	MaxFileMemory int64
-	ReadTimeout   time.Duration
-	WriteTimout   time.Duration
+	Service      Service
+	Logger       *slog.Logger
+	Observer     Observer /* optional */
+	Checks       map[string]Pinger
+	CheckTimeout time.Duration
+	PublicPath   string
+	Addr         string
+	MaxSize      int64
+	MaxExpire    time.Duration /* 0 is unlimited */
+	ReadTimeout  time.Duration
+	WriteTimout  time.Duration
+
+	CreateLimiter  Limiter /* limits creates and updates, nil disables */
+	ReadLimiter    Limiter /* limits reads, nil disables */
+	TrustedProxies []netip.Prefix
+
+	UserMaxSize   int64         /* limits of requests with an API key */
+	UserMaxExpire time.Duration /* 0 is unlimited */
+
+	InlineTypes map[string]bool /* media types served inline, nil uses DefaultInlineTypes */
 }
 
 type Server struct {
-	service       Service
-	logger        *slog.Logger
-	server        *http.Server
-	publicPath    string
-	maxSize       int64
-	maxFileMemory int64
+	service      Service
+	logger       *slog.Logger
+	server       *http.Server
+	publicPath   string
+	maxSize      int64
+	checks       map[string]Pinger
+	checkTimeout time.Duration
+
+	trustedProxies []netip.Prefix
+
+	maxExpire     time.Duration
+	userMaxSize   int64
+	userMaxExpire time.Duration
+
+	inlineTypes map[string]bool
 }
 
 func requestLogger(l *slog.Logger, method, path string) *slog.Logger {
@@ -60,23 +114,164 @@ func New(c Config) *Server {
 		ErrorLog:     slog.NewLogLogger(c.Logger.Handler(), slog.LevelInfo),
 	}
 	api := &Server{
-		maxSize:       c.MaxSize,
-		service:       c.Service,
-		logger:        c.Logger,
-		server:        server,
-		maxFileMemory: c.MaxFileMemory,
-		publicPath:    c.PublicPath,
+		maxSize:      c.MaxSize,
+		service:      c.Service,
+		logger:       c.Logger,
+		server:       server,
+		publicPath:   c.PublicPath,
+		checks:       c.Checks,
+		checkTimeout: c.CheckTimeout,
+
+		trustedProxies: c.TrustedProxies,
+
+		maxExpire:     c.MaxExpire,
+		userMaxSize:   c.UserMaxSize,
+		userMaxExpire: c.UserMaxExpire,
+
+		inlineTypes: c.InlineTypes,
+	}
+
+	if api.inlineTypes == nil {
+		api.inlineTypes = ParseInlineTypes("")
 	}
 
+	if api.checkTimeout <= 0 {
+		api.checkTimeout = defaultCheckTimeout
+	}
+
+	router.Use(traceRequests)
+
+	if c.Observer != nil {
+		router.Use(observe(c.Observer))
+	}
+
+	router.Use(api.authenticate(c.Logger))
+
+	router.Get("/healthz", api.NewHealth())
+	router.Get("/readyz", api.NewReady(requestLogger(c.Logger, "GET", "/readyz")))
+
+	create := api.limit(c.CreateLimiter, c.Logger)
+	read := api.limit(c.ReadLimiter, c.Logger)
+
+	router.Route("/api/v1", func(r chi.Router) {
+		log := requestLogger(c.Logger, "POST", "/api/v1/pastes")
+		r.With(create).Post("/pastes", api.NewAPICreate(log))
+
+		log = requestLogger(c.Logger, "GET", "/api/v1/pastes")
+		r.With(read).Get("/pastes/{id}", api.NewAPIGet(log))
+		r.With(read).Get("/pastes/{id}/rev/{rev}", api.NewAPIGet(log))
+
+		log = requestLogger(c.Logger, "PUT", "/api/v1/pastes")
+		r.With(create).Put("/pastes/{id}", api.NewAPIUpdate(log))
+
+		log = requestLogger(c.Logger, "DELETE", "/api/v1/pastes")
+		r.Delete("/pastes/{id}", api.NewAPIDelete(log))
+
+		log = requestLogger(c.Logger, "GET", "/api/v1/me/pastes")
+		r.With(read).Get("/me/pastes", api.NewAPIMyPastes(log))
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireAdmin)
+
+			log := requestLogger(c.Logger, "GET", "/api/v1/admin/pastes")
+			r.Get("/pastes", api.NewAdminFind(log))
+			r.Get("/pastes/{id}", api.NewAdminFind(log))
+
+			log = requestLogger(c.Logger, "DELETE", "/api/v1/admin/pastes")
+			r.Delete("/pastes/{id}", api.NewAdminDelete(log))
+
+			log = requestLogger(c.Logger, "PUT", "/api/v1/admin/blocks")
+			r.Put("/blocks/{hash}", api.NewAdminBlock(log))
+
+			log = requestLogger(c.Logger, "DELETE", "/api/v1/admin/blocks")
+			r.Delete("/blocks/{hash}", api.NewAdminUnblock(log))
+
+			log = requestLogger(c.Logger, "GET", "/api/v1/admin/stats")
+			r.Get("/stats", api.NewAdminStats(log))
+		})
+	})
+
 	log := requestLogger(c.Logger, "POST", "/")
-	router.Post("/", api.NewUploadForm(log))
+	router.With(create).Post("/", api.NewUploadForm(log))
 
 	log = requestLogger(c.Logger, "GET", "/")
-	router.Get("/{id}", api.NewGet(log))
+	router.With(read).Get("/{id}", api.NewGet(log))
+	router.With(read).Get("/{id}/rev/{rev}", api.NewGet(log))
+
+	log = requestLogger(c.Logger, "PUT", "/")
+	router.With(create).Put("/{id}", api.NewUpdateForm(log))
+
+	log = requestLogger(c.Logger, "DELETE", "/")
+	router.Delete("/{id}", api.NewDelete(log))
 
 	return api
 }
 
+func observe(o Observer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			route := chi.RouteContext(r.Context()).RoutePattern()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+
+			o.ObserveRequest(r.Method, route, code, time.Since(start))
+		})
+	}
+}
+
+// traceRequests starts a server span for every request, continuing the
+// trace of the caller if it sent one.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+
+		span.SetAttributes(attribute.Int("http.status_code", code))
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	})
+}
+
+func (s *Server) pasteURL(id string) string {
+	return fmt.Sprintf("%s/%s", s.publicPath, id)
+}
+
+// Handler returns the routes of the server with their middlewares.
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
 func (s *Server) Run() error {
 	return s.server.ListenAndServe()
 }
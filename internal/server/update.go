@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+const revisionHeader = "X-Revision"
+
+func (s *Server) NewUpdateForm(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		id = strings.TrimSpace(id)
+
+		form, err := s.readForm(w, r)
+		if err != nil {
+			formError(w, logger, err)
+			return
+		}
+		defer form.close()
+
+		token := deleteToken(r)
+		if token == "" {
+			token = strings.TrimSpace(form.values["token"])
+		}
+
+		if id == "" || token == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		content, size, err := parseContent(form)
+		if err != nil {
+			formError(w, logger, err)
+			return
+		}
+
+		info, err := s.service.UpdatePaste(r.Context(), id, token, content, size)
+		if err != nil {
+			var largeErr *http.MaxBytesError
+			if errors.As(err, &largeErr) {
+				http.Error(w, "Message Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			if s.service.IsNoSuchPaste(err) {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
+
+			if s.service.IsInvalidToken(err) || s.service.IsBlocked(err) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			internalError(w)
+			logger.Error("Cannot update paste", l.ErrorAttr(err))
+
+			return
+		}
+
+		w.Header().Set(revisionHeader, strconv.Itoa(info.Revision))
+		w.Write([]byte(s.pasteURL(info.ID)))
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateForm(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		fields []field
+		want   int
+	}{
+		{
+			name:   "header",
+			target: "/4Gp3gCWeXl",
+			header: "secret",
+			fields: []field{{name: "content", value: "dolor sit amet", file: true}},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "token field",
+			target: "/4Gp3gCWeXl",
+			fields: []field{{name: "token", value: "secret"}, {name: "content", value: "dolor sit amet"}},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "token after content",
+			target: "/4Gp3gCWeXl",
+			fields: []field{{name: "content", value: "dolor sit amet"}, {name: "token", value: "secret"}},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "query",
+			target: "/4Gp3gCWeXl?token=secret",
+			fields: []field{{name: "content", value: "dolor sit amet"}},
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "wrong token",
+			target: "/4Gp3gCWeXl",
+			header: "guess",
+			fields: []field{{name: "content", value: "dolor sit amet"}},
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "no paste",
+			target: "/missing",
+			header: "secret",
+			fields: []field{{name: "content", value: "dolor sit amet"}},
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "no content",
+			target: "/4Gp3gCWeXl",
+			header: "secret",
+			fields: []field{{name: "lang", value: "go"}},
+			want:   http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{token: "secret"}
+			srv := New(Config{
+				Service:    svc,
+				Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+				MaxSize:    1024,
+				PublicPath: "http://localhost:8080",
+			})
+
+			body, ct := multipartBody(t, tt.fields)
+
+			r := httptest.NewRequest(http.MethodPut, tt.target, body)
+			r.Header.Set("Content-Type", ct)
+
+			if tt.header != "" {
+				r.Header.Set(deleteTokenHeader, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			if got := w.Header().Get(revisionHeader); got != "2" {
+				t.Errorf("revision is %q, want %q", got, "2")
+			}
+
+			if got := w.Body.String(); got != "http://localhost:8080/4Gp3gCWeXl" {
+				t.Errorf("body is %q, want the paste url", got)
+			}
+
+			if len(svc.updates) != 1 || svc.updates[0] != "dolor sit amet" {
+				t.Errorf("updated with %q, want %q", svc.updates, "dolor sit amet")
+			}
+		})
+	}
+}
+
+func TestAPIUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		header string
+		body   string
+		want   int
+	}{
+		{name: "happy path", target: "/api/v1/pastes/4Gp3gCWeXl", header: "secret", body: `{"content": "dolor sit amet"}`, want: http.StatusOK},
+		{name: "no token", target: "/api/v1/pastes/4Gp3gCWeXl", body: `{"content": "dolor sit amet"}`, want: http.StatusBadRequest},
+		{name: "wrong token", target: "/api/v1/pastes/4Gp3gCWeXl", header: "guess", body: `{"content": "dolor sit amet"}`, want: http.StatusForbidden},
+		{name: "no paste", target: "/api/v1/pastes/missing", header: "secret", body: `{"content": "dolor sit amet"}`, want: http.StatusNotFound},
+		{name: "no content", target: "/api/v1/pastes/4Gp3gCWeXl", header: "secret", body: `{}`, want: http.StatusBadRequest},
+		{name: "too large", target: "/api/v1/pastes/4Gp3gCWeXl", header: "secret", body: `{"content": "` + strings.Repeat("a", 2048) + `"}`, want: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{token: "secret"}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+				MaxSize: 1024,
+			})
+
+			r := httptest.NewRequest(http.MethodPut, tt.target, strings.NewReader(tt.body))
+			if tt.header != "" {
+				r.Header.Set(deleteTokenHeader, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var p apiPaste
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Revision != 2 {
+				t.Errorf("revision is %d, want 2", p.Revision)
+			}
+		})
+	}
+}
+
+func TestGetRevision(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		want     int
+		revision int
+	}{
+		{name: "latest", target: "/4Gp3gCWeXl", want: http.StatusOK},
+		{name: "revision", target: "/4Gp3gCWeXl/rev/1", want: http.StatusOK, revision: 1},
+		{name: "api revision", target: "/api/v1/pastes/4Gp3gCWeXl/rev/1", want: http.StatusOK, revision: 1},
+		{name: "zero", target: "/4Gp3gCWeXl/rev/0", want: http.StatusBadRequest},
+		{name: "not a number", target: "/4Gp3gCWeXl/rev/first", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{info: PasteInfo{ContentType: "text/plain"}, content: "lorem ipsum"}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.want == http.StatusOK && (len(svc.queries) != 1 || svc.queries[0].Revision != tt.revision) {
+				t.Errorf("read %+v, want revision %d", svc.queries, tt.revision)
+			}
+		})
+	}
+}
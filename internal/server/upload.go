@@ -1,12 +1,14 @@
 package server
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -16,117 +18,413 @@ import (
 type Paste struct {
 	BurnAfter int
 	Expire    time.Duration
-	Content   []byte
+	Lang      string
+	Password  string
+	Encrypted bool
+	Content   io.Reader
+	Size      int64 /* -1 if unknown */
+	Owner     int64 /* user id, 0 if anonymous */
+	CreatorIP string
+
+	ContentType string /* sniffed from Content if empty */
+	Filename    string
+}
+
+type PasteInfo struct {
+	ID          string
+	Expire      time.Time
+	BurnAfter   int
+	IsBurnable  bool
+	Size        int64
+	Revision    int
+	Lang        string
+	Encrypted   bool
+	DeleteToken string
+	ContentType string
+	Filename    string
+	Created     time.Time /* only set by ListPastes and FindPastes */
+	Modified    time.Time /* zero for older revisions */
+	Protected   bool      /* has a password */
+	Hash        string    /* content hash */
+	CreatorIP   string    /* only set by FindPastes */
+	Owner       int64     /* only set by FindPastes */
+
+	Encoding string /* content coding the paste is served in, "" for identity */
+	Partial  bool   /* only Length bytes from Offset are served */
+	Offset   int64
+	Length   int64
 }
 
 var errBadValue = errors.New("bad value")
 
-func (s *Server) parseRequest(form *multipart.Form) (Paste, error) {
+// bcrypt ignores everything after 72 bytes.
+const maxPasswordLength = 72
+
+func parseExpire(v string) (time.Duration, error) {
+	expire, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.Join(err, errBadValue)
+	}
+
+	if expire <= 0 {
+		return 0, errBadValue
+	}
+
+	return expire, nil
+}
+
+func validateBurn(burn int) error {
+	if burn < 0 {
+		return errBadValue
+	}
+
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) > maxPasswordLength {
+		return errBadValue
+	}
+
+	return nil
+}
+
+func validateLang(lang string) error {
+	if lang != "" && !isValidLang(lang) {
+		return errBadValue
+	}
+
+	return nil
+}
+
+// maxFieldSize limits the form fields besides content.
+const maxFieldSize = 4 << 10
+
+// spoolMemory is how much of the content is kept in memory while the rest
+// of the form is read, larger content is spooled to a temporary file.
+const spoolMemory = 1 << 20
+
+// uploadForm is a multipart upload with its content spooled, so fields may
+// come in any order.
+type uploadForm struct {
+	values      map[string]string
+	content     io.Reader /* nil if the form has none */
+	size        int64
+	filename    string   /* of the content part */
+	contentType string   /* header of the content part */
+	file        *os.File /* spooled content, removed by close */
+}
+
+// readForm reads a multipart upload limited by the size limit of the
+// request. The form has to be closed.
+func (s *Server) readForm(w http.ResponseWriter, r *http.Request) (_ *uploadForm, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxSizeFor(r))
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.Join(err, errBadValue)
+	}
+
+	form := &uploadForm{values: make(map[string]string)}
+	defer func() {
+		if err != nil {
+			form.close()
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+
+		if err != nil {
+			return nil, partError(err)
+		}
+
+		name := part.FormName()
+
+		// The first value counts like it does with ParseMultipartForm.
+		if name == "content" {
+			if form.content == nil {
+				if err = form.spool(part); err != nil {
+					return nil, err
+				}
+			}
+
+			continue
+		}
+
+		v, err := io.ReadAll(io.LimitReader(part, maxFieldSize+1))
+		if err != nil {
+			return nil, partError(err)
+		}
+
+		if len(v) > maxFieldSize {
+			return nil, errBadValue
+		}
+
+		if _, ok := form.values[name]; !ok && name != "" {
+			form.values[name] = string(v)
+		}
+	}
+}
+
+// partReader remembers the error of reading the part, telling it from
+// errors of the spool.
+type partReader struct {
+	part *multipart.Part
+	err  error
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	n, err := p.part.Read(b)
+	if err != nil && !errors.Is(err, io.EOF) {
+		p.err = err
+	}
+
+	return n, err
+}
+
+// spool keeps the content of part in memory up to spoolMemory and in a
+// temporary file beyond it.
+func (f *uploadForm) spool(part *multipart.Part) error {
+	f.filename = part.FileName()
+	f.contentType = part.Header.Get("Content-Type")
+
+	r := &partReader{part: part}
+
+	var buf bytes.Buffer
+
+	n, err := io.CopyN(&buf, r, spoolMemory+1)
+	if errors.Is(err, io.EOF) {
+		f.content, f.size = bytes.NewReader(buf.Bytes()), n
+		return nil
+	}
+
+	if err != nil {
+		return partError(err)
+	}
+
+	f.file, err = os.CreateTemp("", "gopetbin-upload-*")
+	if err != nil {
+		return fmt.Errorf("cannot spool content: %w", err)
+	}
+
+	n, err = io.Copy(f.file, io.MultiReader(&buf, r))
+	if r.err != nil {
+		return partError(r.err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot spool content: %w", err)
+	}
+
+	if _, err = f.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot spool content: %w", err)
+	}
+
+	f.content, f.size = f.file, n
+
+	return nil
+}
+
+// close removes the spooled content.
+func (f *uploadForm) close() {
+	if f.file != nil {
+		f.file.Close()
+		os.Remove(f.file.Name())
+	}
+}
+
+// partError tells a body over the size limit from a malformed one.
+func partError(err error) error {
+	var largeErr *http.MaxBytesError
+	if errors.As(err, &largeErr) {
+		return err
+	}
+
+	return errors.Join(err, errBadValue)
+}
+
+func parseContent(form *uploadForm) (io.Reader, int64, error) {
+	if form.content == nil {
+		return nil, 0, errBadValue
+	}
+
+	return form.content, form.size, nil
+}
+
+// parseContentMeta returns the type and filename of the content. The
+// content_type and filename fields take precedence over the headers of
+// the uploaded file, which are ignored if malformed.
+func parseContentMeta(form *uploadForm) (string, string, error) {
+	var ct, filename string
+
+	if form.content != nil && form.filename != "" {
+		ct, _ = parseContentType(form.contentType)
+
+		if validateFilename(form.filename) == nil {
+			filename = form.filename
+		}
+	}
+
+	var err error
+
+	if v, ok := form.values["content_type"]; ok {
+		if ct, err = parseContentType(v); err != nil {
+			return "", "", err
+		}
+	}
+
+	if v, ok := form.values["filename"]; ok {
+		if err = validateFilename(v); err != nil {
+			return "", "", err
+		}
+
+		filename = v
+	}
+
+	return ct, filename, nil
+}
+
+func (s *Server) parseRequest(form *uploadForm) (Paste, error) {
 	var expire time.Duration
 	var err error
 
-	v, ok := form.Value["expire"]
+	v, ok := form.values["expire"]
 	if ok {
-		expire, err = time.ParseDuration(v[0])
+		expire, err = parseExpire(v)
 		if err != nil {
-			return Paste{}, errors.Join(err, errBadValue)
-		}
-
-		if expire <= 0 {
-			return Paste{}, errBadValue
+			return Paste{}, err
 		}
 	}
 
 	var burn int
 
-	v, ok = form.Value["burn"]
+	v, ok = form.values["burn"]
 	if ok {
-		burn, err = strconv.Atoi(v[0])
+		burn, err = strconv.Atoi(v)
 		if err != nil {
 			return Paste{}, errors.Join(err, errBadValue)
 		}
 
-		if burn < 0 {
-			return Paste{}, errBadValue
+		if err = validateBurn(burn); err != nil {
+			return Paste{}, err
 		}
 	}
 
-	var content []byte
+	var lang string
 
-	c, ok := form.Value["content"]
+	v, ok = form.values["lang"]
 	if ok {
-		content = []byte(c[0])
-	} else {
-		files, ok := form.File["content"]
-		if !ok {
-			return Paste{}, errBadValue
+		lang = v
+		if err = validateLang(lang); err != nil {
+			return Paste{}, err
 		}
+	}
 
-		f, err := files[0].Open()
-		if err != nil {
+	var password string
+
+	v, ok = form.values["password"]
+	if ok {
+		password = v
+		if err = validatePassword(password); err != nil {
 			return Paste{}, err
 		}
+	}
 
-		content, err = io.ReadAll(f)
+	var encrypted bool
+
+	v, ok = form.values["encrypted"]
+	if ok {
+		encrypted, err = strconv.ParseBool(v)
 		if err != nil {
-			return Paste{}, err
+			return Paste{}, errors.Join(err, errBadValue)
 		}
 	}
 
+	ct, filename, err := parseContentMeta(form)
+	if err != nil {
+		return Paste{}, err
+	}
+
+	content, size, err := parseContent(form)
+	if err != nil {
+		return Paste{}, err
+	}
+
 	return Paste{
-		Expire:    expire,
-		BurnAfter: burn,
-		Content:   content,
+		Expire:      expire,
+		BurnAfter:   burn,
+		Lang:        lang,
+		Password:    password,
+		Encrypted:   encrypted,
+		Content:     content,
+		Size:        size,
+		ContentType: ct,
+		Filename:    filename,
 	}, nil
 }
 
+// formError writes the response to a form that cannot be read.
+func formError(w http.ResponseWriter, logger *slog.Logger, err error) {
+	var largeErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &largeErr):
+		http.Error(w, "Message Too Large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errBadValue):
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+	default:
+		internalError(w)
+		logger.Error("Cannot parse request", l.ErrorAttr(err))
+	}
+}
+
 func (s *Server) NewUploadForm(logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, s.maxSize)
-
-		err := r.ParseMultipartForm(s.maxFileMemory)
+		form, err := s.readForm(w, r)
 		if err != nil {
-			if errors.Is(err, multipart.ErrMessageTooLarge) {
-				http.Error(w, "Message Too Large", http.StatusRequestEntityTooLarge)
-				return
-			}
-
-			var largeErr *http.MaxBytesError
-			if errors.As(err, &largeErr) {
-				http.Error(w, "Message Too Large", http.StatusRequestEntityTooLarge)
-				return
-			}
+			formError(w, logger, err)
+			return
+		}
+		defer form.close()
 
-			internalError(w)
-			s.logger.Error("Cannot parse multipart form", l.ErrorAttr(err))
+		paste, err := s.parseRequest(form)
+		if err != nil {
+			formError(w, logger, err)
+			return
+		}
 
+		if !s.validExpire(r, paste.Expire) {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
 
-		defer r.MultipartForm.RemoveAll()
+		paste.Owner = owner(r)
+		paste.CreatorIP = s.clientIP(r)
 
-		paste, err := s.parseRequest(r.MultipartForm)
+		info, err := s.service.CreatePaste(r.Context(), paste)
 		if err != nil {
-			if errors.Is(err, errBadValue) {
-				http.Error(w, "Bad Request", http.StatusBadRequest)
+			var largeErr *http.MaxBytesError
+			if errors.As(err, &largeErr) {
+				http.Error(w, "Message Too Large", http.StatusRequestEntityTooLarge)
 				return
 			}
 
-			internalError(w)
-			s.logger.Error("Cannot parse request", l.ErrorAttr(err))
-
-			return
-		}
+			if s.service.IsBlocked(err) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
 
-		id, err := s.service.CreatePaste(r.Context(), paste)
-		if err != nil {
 			internalError(w)
-			s.logger.Error("Cannot create paste", l.ErrorAttr(err))
+			logger.Error("Cannot create paste", l.ErrorAttr(err))
 
 			return
 		}
 
-		result := fmt.Sprintf("%s/%s", s.publicPath, id)
-		w.Write([]byte(result))
+		w.Header().Set(deleteTokenHeader, info.DeleteToken)
+		w.Write([]byte(s.pasteURL(info.ID)))
 	}
 }
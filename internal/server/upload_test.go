@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type field struct {
+	name, value string
+	file        bool
+}
+
+func multipartBody(t *testing.T, fields []field) (io.Reader, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	for _, f := range fields {
+		var (
+			part io.Writer
+			err  error
+		)
+
+		if f.file {
+			part, err = w.CreateFormFile(f.name, "notes.md")
+		} else {
+			part, err = w.CreateFormField(f.name)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.WriteString(part, f.value)
+	}
+
+	w.Close()
+
+	return &body, w.FormDataContentType()
+}
+
+func TestUploadForm(t *testing.T) {
+	tests := []struct {
+		name     string
+		fields   []field
+		want     int
+		created  bool
+		wantLang string
+	}{
+		{
+			name:     "fields before file",
+			fields:   []field{{name: "lang", value: "go"}, {name: "content", value: "package main", file: true}},
+			want:     http.StatusOK,
+			created:  true,
+			wantLang: "go",
+		},
+		{
+			name:    "value content",
+			fields:  []field{{name: "content", value: "lorem ipsum"}},
+			want:    http.StatusOK,
+			created: true,
+		},
+		{
+			name:     "fields after content",
+			fields:   []field{{name: "content", value: "lorem ipsum"}, {name: "lang", value: "go"}},
+			want:     http.StatusOK,
+			created:  true,
+			wantLang: "go",
+		},
+		{
+			name:   "bad field after content",
+			fields: []field{{name: "content", value: "lorem ipsum"}, {name: "burn", value: "-1"}},
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "no content",
+			fields: []field{{name: "lang", value: "go"}},
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "bad field",
+			fields: []field{{name: "burn", value: "-1"}, {name: "content", value: "lorem ipsum"}},
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "too large",
+			fields: []field{{name: "content", value: strings.Repeat("a", 2048), file: true}},
+			want:   http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeService{}
+			srv := New(Config{
+				Service: svc,
+				Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+				MaxSize: 1024,
+			})
+
+			body, ct := multipartBody(t, tt.fields)
+			r := httptest.NewRequest(http.MethodPost, "/", body)
+			r.Header.Set("Content-Type", ct)
+
+			w := httptest.NewRecorder()
+			srv.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status is %d, want %d", w.Code, tt.want)
+			}
+
+			if got := len(svc.created) > 0; got != tt.created {
+				t.Fatalf("created is %v, want %v", got, tt.created)
+			}
+
+			if tt.created && svc.created[0].Lang != tt.wantLang {
+				t.Errorf("lang is %q, want %q", svc.created[0].Lang, tt.wantLang)
+			}
+		})
+	}
+}
+
+// Large content is spooled to a file that is gone once the paste is created.
+func TestUploadSpool(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	svc := &fakeService{}
+	srv := New(Config{
+		Service: svc,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		MaxSize: 4 * spoolMemory,
+	})
+
+	content := strings.Repeat("a", 2*spoolMemory)
+
+	body, ct := multipartBody(t, []field{{name: "content", value: content, file: true}, {name: "lang", value: "go"}})
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", ct)
+
+	w := httptest.NewRecorder()
+	srv.server.Handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status is %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if len(svc.created) != 1 || svc.uploads[0] != content || svc.created[0].Size != int64(len(content)) {
+		t.Fatal("content not created whole")
+	}
+
+	if svc.created[0].Lang != "go" {
+		t.Errorf("lang is %q, want go", svc.created[0].Lang)
+	}
+
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("spooled files left: %v", left)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+	"github.com/swmh/gopetbin/internal/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PasteFilter selects pastes for Repository.FindPastes, empty fields match
+// everything.
+type PasteFilter struct {
+	ID     string
+	Names  []string /* blob names, match any revision */
+	Limit  int
+	Offset int
+}
+
+// FindPastes looks pastes up for moderation, including expired and burned
+// ones that are not cleaned up yet.
+func (s *Service) FindPastes(ctx context.Context, f server.PasteFilter) ([]server.PasteInfo, error) {
+	filter := PasteFilter{ID: f.ID, Limit: f.Limit, Offset: f.Offset}
+	if f.Hash != "" {
+		filter.Names = []string{f.Hash, f.Hash + zstdExt}
+	}
+
+	pastes, err := s.repo.FindPastes(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find pastes in repo: %w", err)
+	}
+
+	infos := make([]server.PasteInfo, 0, len(pastes))
+
+	for _, p := range pastes {
+		infos = append(infos, server.PasteInfo{
+			ID:         p.ID,
+			Expire:     p.Expire,
+			BurnAfter:  p.BurnAfter,
+			IsBurnable: p.IsBurnable,
+			Size:       p.Size,
+			Revision:   p.Revision,
+			Lang:       p.Lang,
+			Encrypted:  p.Encrypted,
+			Created:    p.Created,
+			Hash:       contentHash(p.Name),
+			CreatorIP:  p.CreatorIP,
+			Owner:      p.Owner,
+		})
+	}
+
+	return infos, nil
+}
+
+// ForceDeletePaste removes the paste without a delete token and reclaims
+// the content right away instead of leaving it to the cleaner.
+func (s *Service) ForceDeletePaste(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "Service.ForceDeletePaste", trace.WithAttributes(attribute.String("paste.id", id)))
+	defer span.End()
+
+	mutex, err := s.locker.Lock(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot acquire lock: %w", err)
+	}
+
+	defer func() {
+		if err = mutex.Unlock(ctx); err != nil {
+			s.logger.Error("Cannot unlock", l.ErrorAttr(err))
+		}
+	}()
+
+	unreferenced, err := s.repo.DeletePaste(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot delete paste from repo: %w", err)
+	}
+
+	s.invalidate(ctx, id)
+
+	// Blobs left behind on failure are picked up by the cleaner.
+	for _, name := range unreferenced {
+		err = s.repo.DeleteBlob(ctx, name, s.remove(name))
+		if err != nil && !s.repo.IsNoSuchPaste(err) {
+			s.logger.Warn("Cannot delete blob", slog.String("name", name), l.ErrorAttr(err))
+		}
+	}
+
+	return nil
+}
+
+// remove deletes the stored content of a blob, content that is gone
+// already is fine.
+func (s *Service) remove(name string) BlobFunc {
+	return func(ctx context.Context) error {
+		if err := s.storage.DeleteFile(ctx, name); err != nil && !s.storage.IsNoSuchPaste(err) {
+			return fmt.Errorf("cannot delete file: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// BlobStats reports how much storage deduplication saves.
+func (s *Service) BlobStats(ctx context.Context) (server.BlobStats, error) {
+	st, err := s.repo.BlobStats(ctx)
+	if err != nil {
+		return server.BlobStats{}, fmt.Errorf("cannot get blob stats from repo: %w", err)
+	}
+
+	return server.BlobStats{
+		Blobs:      st.Blobs,
+		References: st.References,
+		Size:       st.Size,
+		Saved:      st.Saved,
+	}, nil
+}
+
+// BlockHash rejects content with the hash from being uploaded again.
+// Pastes already having it are not touched.
+func (s *Service) BlockHash(ctx context.Context, hash string, reason string) error {
+	if err := s.repo.BlockHash(ctx, hash, reason); err != nil {
+		return fmt.Errorf("cannot block hash in repo: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Service) UnblockHash(ctx context.Context, hash string) error {
+	if err := s.repo.UnblockHash(ctx, hash); err != nil {
+		return fmt.Errorf("cannot unblock hash in repo: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"strings"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+)
+
+var errChecksum = errors.New("checksum mismatch")
+
+// encPrefix is put before the random names of encrypted blobs.
+const encPrefix = "enc/"
+
+func newHash() hash.Hash {
+	return sha256.New()
+}
+
+func getName(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digest passes the stored bytes of a blob through while hashing the
+// content they decode to. Compressed blobs are decoded on the side.
+type digest struct {
+	io.Reader
+	h    hash.Hash
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+	over bool
+}
+
+func newDigest(stored io.Reader, codec string) *digest {
+	d := &digest{h: newHash()}
+
+	if codec == "" {
+		d.Reader = io.TeeReader(stored, d.h)
+		return d
+	}
+
+	pr, pw := io.Pipe()
+	d.pw, d.done = pw, make(chan error, 1)
+	d.Reader = io.TeeReader(stored, pw)
+
+	go func() {
+		dec, err := decode(io.NopCloser(pr), codec)
+		if err == nil {
+			_, err = io.Copy(d.h, dec)
+			dec.Close()
+		}
+
+		// A blob that does not decode is corrupt as well. Fails the writes
+		// if the decoder gave up before the end.
+		if err != nil {
+			err = fmt.Errorf("%w: %w", errChecksum, err)
+		}
+
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+
+	return d
+}
+
+// finish waits for the decoder, err is nil once all stored bytes are read.
+func (d *digest) finish(err error) error {
+	if d.over {
+		return d.err
+	}
+
+	d.over = true
+
+	if d.pw != nil {
+		if err == nil {
+			d.pw.Close()
+		} else {
+			d.pw.CloseWithError(err)
+		}
+
+		d.err = <-d.done
+	}
+
+	return d.err
+}
+
+// sum returns the checksum of the content, the stored bytes must be read
+// to EOF first.
+func (d *digest) sum() (string, error) {
+	if err := d.finish(nil); err != nil {
+		return "", err
+	}
+
+	return getName(d.h), nil
+}
+
+// abort stops the decoder of a blob that is not read to the end.
+func (d *digest) abort() {
+	d.finish(io.ErrUnexpectedEOF)
+}
+
+// verifier fails the read with errChecksum at EOF if the blob does not
+// match its checksum, so a client never gets a clean end of corrupt content.
+type verifier struct {
+	d     *digest
+	blob  io.Closer
+	want  string
+	fail  func()
+	ended bool
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.d.Read(p)
+
+	switch {
+	case v.ended:
+		return n, err
+	case errors.Is(err, errChecksum):
+		v.ended = true
+		v.fail()
+
+		return n, err
+	case !errors.Is(err, io.EOF):
+		return n, err
+	}
+
+	v.ended = true
+
+	if sum, serr := v.d.sum(); serr != nil || sum != v.want {
+		v.fail()
+		return n, errChecksum
+	}
+
+	return n, err
+}
+
+func (v *verifier) Close() error {
+	v.d.abort()
+	return v.blob.Close()
+}
+
+// verify checks a blob streamed from storage against checksum, codec is
+// the encoding of blob as it is read. Pastes stored before checksums were
+// recorded are not checked. The mismatch is only known at the end, once
+// the content has been sent.
+func (s *Service) verify(ctx context.Context, id, name string, blob io.ReadCloser, codec, checksum string) io.ReadCloser {
+	if checksum == "" {
+		return blob
+	}
+
+	return &verifier{
+		d:    newDigest(blob, codec),
+		blob: blob,
+		want: checksum,
+		fail: func() { s.mismatch(ctx, id, name) },
+	}
+}
+
+// check compares a blob read into memory with checksum.
+func check(data []byte, codec, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+
+	d := newDigest(bytes.NewReader(data), codec)
+
+	if _, err := io.Copy(io.Discard, d); err != nil {
+		d.abort()
+		return err
+	}
+
+	sum, err := d.sum()
+	if err != nil {
+		return err
+	}
+
+	if sum != checksum {
+		return errChecksum
+	}
+
+	return nil
+}
+
+// mismatch reports a corrupt blob and drops the file cache entry so the
+// next read goes to storage.
+func (s *Service) mismatch(ctx context.Context, id, name string) {
+	s.logger.Error("Checksum mismatch", slog.String("key", id), slog.String("name", name))
+
+	if err := s.fileCache.Delete(ctx, id); err != nil {
+		s.logger.Warn("Cannot delete value from file cache", slog.String("key", id), l.ErrorAttr(err))
+	}
+}
+
+// MigrateBlobs records checksums of up to limit blobs stored without one,
+// ordered by name after the given one, and returns the last name handled,
+// "" once none are left. md5 named blobs are copied to their SHA-256 name
+// unless it is blocked, the old blob is left to the cleaner.
+func (s *Service) MigrateBlobs(ctx context.Context, after string, limit int) (string, error) {
+	names, err := s.repo.LegacyBlobs(ctx, after, limit)
+	if err != nil {
+		return "", fmt.Errorf("cannot get legacy blobs from repo: %w", err)
+	}
+
+	for _, name := range names {
+		if err = s.migrateBlob(ctx, name); err != nil {
+			s.logger.Warn("Cannot migrate blob", slog.String("name", name), l.ErrorAttr(err))
+		}
+	}
+
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	return names[len(names)-1], nil
+}
+
+func (s *Service) migrateBlob(ctx context.Context, name string) error {
+	// The copy is of the stored bytes, so its size is known up front.
+	size, err := s.storage.FileSize(ctx, name)
+	if err != nil {
+		return fmt.Errorf("cannot stat file in storage: %w", err)
+	}
+
+	file, err := s.storage.GetFile(ctx, name)
+	if err != nil {
+		return fmt.Errorf("cannot get file from storage: %w", err)
+	}
+	defer file.Close()
+
+	codec := codecOf(name)
+
+	d := newDigest(file, codec)
+	defer d.abort()
+
+	// Encrypted blobs keep their random name.
+	if strings.HasPrefix(name, encPrefix) {
+		if _, err = io.Copy(io.Discard, d); err != nil {
+			return fmt.Errorf("cannot read file: %w", err)
+		}
+
+		sum, err := d.sum()
+		if err != nil {
+			return err
+		}
+
+		return s.rename(ctx, name, &blob{name: name, checksum: sum, placed: true})
+	}
+
+	tmp, err := tmpName()
+	if err != nil {
+		return err
+	}
+
+	if err = s.storage.PutFile(ctx, tmp, d, size, codec); err != nil {
+		return err
+	}
+
+	b := blob{tmp: tmp}
+
+	b.checksum, err = d.sum()
+	if err != nil {
+		s.discard(ctx, &b)
+		return err
+	}
+
+	// Pastes keep the old name rather than move to a blocked one.
+	blocked, err := s.isBlocked(ctx, b.checksum)
+	if err != nil || blocked {
+		s.discard(ctx, &b)
+
+		if err != nil {
+			return fmt.Errorf("cannot check block in repo: %w", err)
+		}
+
+		return errBlocked
+	}
+
+	b.name = b.checksum + strings.TrimPrefix(name, contentHash(name))
+
+	err = s.rename(ctx, name, &b)
+	s.discard(ctx, &b)
+
+	return err
+}
+
+// rename points every paste at the migrated blob and drops the cached
+// pastes that still refer to the old name.
+func (s *Service) rename(ctx context.Context, old string, b *blob) error {
+	ids, err := s.repo.RenameBlob(ctx, old, b.name, b.checksum, s.place(b))
+	if err != nil {
+		return fmt.Errorf("cannot rename blob in repo: %w", err)
+	}
+
+	var errs []error
+
+	for _, id := range ids {
+		errs = append(errs, s.uncache(ctx, id))
+	}
+
+	return errors.Join(errs...)
+}
+
+// uncache drops the cached paste under its lock. A read holding the lock
+// may have got the paste before it was renamed and caches it on the way
+// out, dropping it without the lock could leave that entry behind.
+func (s *Service) uncache(ctx context.Context, id string) error {
+	mutex, err := s.locker.Lock(ctx, id)
+	if err != nil {
+		if derr := s.cache.Delete(ctx, id); derr != nil {
+			s.logger.Warn("Cannot delete value from cache", slog.String("key", id), l.ErrorAttr(derr))
+		}
+
+		return fmt.Errorf("cannot acquire lock: %w", err)
+	}
+
+	defer func() {
+		if err := mutex.Unlock(ctx); err != nil {
+			s.logger.Error("Cannot unlock", l.ErrorAttr(err))
+		}
+	}()
+
+	if err = s.cache.Delete(ctx, id); err != nil {
+		s.logger.Warn("Cannot delete value from cache", slog.String("key", id), l.ErrorAttr(err))
+	}
+
+	return nil
+}
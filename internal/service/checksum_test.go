@@ -0,0 +1,225 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/swmh/gopetbin/internal/server"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+)
+
+// uploads records the sizes content is uploaded with and calls beforeGet,
+// if set, before each GetFile.
+type uploads struct {
+	*memory.Storage
+	mu        sync.Mutex
+	sizes     []int64
+	beforeGet func()
+}
+
+func (u *uploads) GetFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	if u.beforeGet != nil {
+		u.beforeGet()
+	}
+
+	return u.Storage.GetFile(ctx, name)
+}
+
+func (u *uploads) PutFile(ctx context.Context, name string, data io.Reader, size int64, codec string) error {
+	u.mu.Lock()
+	u.sizes = append(u.sizes, size)
+	u.mu.Unlock()
+
+	return u.Storage.PutFile(ctx, name, data, size, codec)
+}
+
+type env struct {
+	service   *service.Service
+	storage   *uploads
+	fileCache *servicetest.FileCache
+	tokens    map[string]string /* delete tokens by paste id */
+}
+
+func newEnv(t *testing.T, fileCacheMaxSize int64) env {
+	t.Helper()
+
+	e := env{
+		storage:   &uploads{Storage: memory.New()},
+		fileCache: servicetest.NewFileCache(),
+		tokens:    make(map[string]string),
+	}
+
+	e.service = servicetest.NewService(t, service.Config{
+		Storage:          e.storage,
+		FileCache:        e.fileCache,
+		FileCacheMaxSize: fileCacheMaxSize,
+	})
+
+	return e
+}
+
+// create stores content and returns the paste id and the blob name.
+func (e env) create(t *testing.T, content, contentType string) (string, string) {
+	t.Helper()
+
+	info, err := e.service.CreatePaste(context.Background(), server.Paste{
+		Content:     strings.NewReader(content),
+		Size:        int64(len(content)),
+		ContentType: contentType,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.tokens[info.ID] = info.DeleteToken
+
+	stat, err := e.service.StatPaste(context.Background(), server.Query{ID: info.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Content is stored compressed only if that makes it smaller.
+	name := stat.Hash + ".zst"
+	if _, err = e.storage.GetFile(context.Background(), name); err != nil {
+		name = stat.Hash
+	}
+
+	return info.ID, name
+}
+
+func (e env) corrupt(t *testing.T, name, stored string) {
+	t.Helper()
+
+	if err := e.storage.PutFile(context.Background(), name, strings.NewReader(stored), int64(len(stored)), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the error of GetPaste or of reading the content.
+func (e env) read(q server.Query) (string, error) {
+	_, file, err := e.service.GetPaste(context.Background(), q)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+
+	return string(data), err
+}
+
+func TestCorruptBlob(t *testing.T) {
+	const binary = "\x00\x01\x02\x03 binary content"
+
+	text := strings.Repeat("lorem ipsum dolor sit amet ", 8)
+
+	tests := []struct {
+		name        string
+		maxSize     int64
+		content     string
+		contentType string
+		stored      string
+		encodings   []string
+		wantGetErr  bool
+	}{
+		{name: "raw streamed", maxSize: -1, content: binary, contentType: "application/octet-stream", stored: "\x00\x01\x02\x03 binary CONTENT"},
+		{name: "raw buffered", maxSize: 1 << 20, content: binary, contentType: "application/octet-stream", stored: "\x00\x01\x02\x03 binary CONTENT", wantGetErr: true},
+		{name: "zstd streamed", maxSize: -1, content: text, contentType: "text/plain", stored: "garbage"},
+		{name: "zstd passed through", maxSize: -1, content: text, contentType: "text/plain", stored: "garbage", encodings: []string{"zstd"}},
+		{name: "zstd buffered", maxSize: 1 << 20, content: text, contentType: "text/plain", stored: "garbage", wantGetErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, tt.maxSize)
+			id, name := e.create(t, tt.content, tt.contentType)
+			e.corrupt(t, name, tt.stored)
+
+			_, file, err := e.service.GetPaste(context.Background(), server.Query{ID: id, Encodings: tt.encodings})
+			if tt.wantGetErr {
+				if !e.service.IsChecksumMismatch(err) {
+					t.Fatalf("GetPaste error is %v, want a checksum mismatch", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			if _, err = io.ReadAll(file); !e.service.IsChecksumMismatch(err) {
+				t.Fatalf("read error is %v, want a checksum mismatch", err)
+			}
+		})
+	}
+}
+
+func TestCorruptFileCache(t *testing.T) {
+	const text = "lorem ipsum dolor sit amet"
+
+	e := newEnv(t, 1<<20)
+	id, _ := e.create(t, text, "text/plain")
+
+	if _, err := e.read(server.Query{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.fileCache.Set(context.Background(), id, []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := e.read(server.Query{ID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != text {
+		t.Errorf("content is %q, want %q", got, text)
+	}
+}
+
+func TestCompressOnlySmaller(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		compressed  bool
+		streamed    bool /* uploaded without knowing its stored size */
+	}{
+		{name: "short text", content: "lorem ipsum", contentType: "text/plain"},
+		{name: "repetitive text", content: strings.Repeat("lorem ipsum ", 100), contentType: "text/plain", compressed: true},
+		{name: "large text", content: strings.Repeat("lorem ipsum ", 20000), contentType: "text/plain", compressed: true, streamed: true},
+		{name: "binary", content: strings.Repeat("\x00", 1000), contentType: "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv(t, 0)
+			id, name := e.create(t, tt.content, tt.contentType)
+
+			if got := strings.HasSuffix(name, ".zst"); got != tt.compressed {
+				t.Errorf("blob %s compressed is %v, want %v", name, got, tt.compressed)
+			}
+
+			if got := e.storage.sizes[0] < 0; got != tt.streamed {
+				t.Errorf("uploaded with size %d, want streamed %v", e.storage.sizes[0], tt.streamed)
+			}
+
+			got, err := e.read(server.Query{ID: id})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.content {
+				t.Errorf("content is %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
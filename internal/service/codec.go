@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CodecZstd is the only codec blobs are compressed with. The blob name is
+// what reads go by: it is fixed together with the bytes when the upload is
+// placed, so it can never disagree with them, and it moves with the blob
+// between storage backends that keep no object metadata. Storage may keep
+// the codec in the object metadata as well. Names without the extension
+// are raw blobs.
+const (
+	CodecZstd = "zstd"
+	zstdExt   = ".zst"
+)
+
+// sampleSize is how much of the content decides whether it is compressed,
+// all of it for small pastes.
+const sampleSize = 64 << 10
+
+var sampler, _ = zstd.NewWriter(nil)
+
+// codecOf returns the codec of the blob, "" for raw blobs.
+func codecOf(name string) string {
+	if strings.HasSuffix(name, zstdExt) {
+		return CodecZstd
+	}
+
+	return ""
+}
+
+// contentHash strips the codec from a blob name.
+func contentHash(name string) string {
+	return strings.TrimSuffix(name, zstdExt)
+}
+
+// compressible tells whether content of the type is worth compressing,
+// media and archives are compressed already.
+func compressible(ct string) bool {
+	mt, _, _ := strings.Cut(ct, ";")
+	mt = strings.TrimSpace(mt)
+
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/xml", mt == "application/javascript":
+		return true
+	case strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+
+	return false
+}
+
+// shrink compresses head whole, ok tells whether that makes it smaller,
+// short content often grows by the frame overhead.
+func shrink(head []byte) (_ []byte, ok bool) {
+	enc := sampler.EncodeAll(head, make([]byte, 0, len(head)))
+	return enc, len(enc) < len(head)
+}
+
+// compress streams the zstd encoding of r.
+func compress(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		enc, err := zstd.NewWriter(pw, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err = io.Copy(enc, r); err != nil {
+			enc.Close()
+			pw.CloseWithError(err)
+
+			return
+		}
+
+		pw.CloseWithError(enc.Close())
+	}()
+
+	return pr
+}
+
+// decode wraps a blob stored with codec so that it reads as the original
+// content, closing it closes the blob too. A blob that does not decode is
+// reported as errChecksum.
+func decode(blob io.ReadCloser, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case "":
+		return blob, nil
+	case CodecZstd:
+		stored := &storedReader{Reader: blob}
+
+		dec, err := zstd.NewReader(stored, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			blob.Close()
+			return nil, fmt.Errorf("cannot decode blob: %w", err)
+		}
+
+		return readCloser{&decoded{dec, stored}, closers{dec.IOReadCloser(), blob}}, nil
+	default:
+		blob.Close()
+		return nil, fmt.Errorf("unknown codec %q", codec)
+	}
+}
+
+// storedReader remembers whether reading the stored bytes failed.
+type storedReader struct {
+	io.Reader
+	failed atomic.Bool
+}
+
+func (r *storedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.failed.Store(true)
+	}
+
+	return n, err
+}
+
+// decoded tells decoder errors from errors of reading the blob.
+type decoded struct {
+	io.Reader
+	stored *storedReader
+}
+
+func (d *decoded) Read(p []byte) (int, error) {
+	n, err := d.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && !d.stored.failed.Load() {
+		err = fmt.Errorf("%w: %w", errChecksum, err)
+	}
+
+	return n, err
+}
+
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+
+	for _, v := range c {
+		if cerr := v.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// section skips to offset of r and reads at most length bytes, for ranges
+// of compressed blobs that cannot be fetched directly.
+func section(r io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("cannot skip to offset: %w", err)
+	}
+
+	return readCloser{io.LimitReader(r, length), r}, nil
+}
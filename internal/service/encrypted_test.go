@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/swmh/gopetbin/internal/server"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+)
+
+// Encrypted pastes get a random name each, equal ciphertexts are neither
+// shared nor compressed.
+func TestPutEncrypted(t *testing.T) {
+	ctx := context.Background()
+
+	strg := memory.New()
+	repo := servicetest.NewRepo(t)
+	s := servicetest.NewService(t, service.Config{Storage: strg, Repo: repo})
+
+	ciphertext := strings.Repeat("c2VjcmV0", 64)
+
+	var names []string
+
+	for i := 0; i < 2; i++ {
+		info, err := s.CreatePaste(ctx, server.Paste{Encrypted: true, Content: strings.NewReader(ciphertext), Size: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := repo.GetPaste(ctx, info.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !p.Encrypted || p.ContentType != "application/octet-stream" {
+			t.Errorf("paste is encrypted %v with type %q", p.Encrypted, p.ContentType)
+		}
+
+		names = append(names, p.Name)
+
+		f, err := strg.GetFile(ctx, p.Name)
+		if err != nil {
+			t.Fatalf("blob %s not stored: %v", p.Name, err)
+		}
+
+		if stored, _ := io.ReadAll(f); string(stored) != ciphertext {
+			t.Errorf("stored %d bytes, want the ciphertext as is", len(stored))
+		}
+
+		_, file, err := s.GetPaste(ctx, server.Query{ID: info.ID, Encodings: []string{"zstd"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := io.ReadAll(file); string(got) != ciphertext {
+			t.Error("ciphertext not served as is")
+		}
+
+		file.Close()
+	}
+
+	if names[0] == names[1] || !strings.HasPrefix(names[0], "enc/") || !strings.HasPrefix(names[1], "enc/") {
+		t.Errorf("blobs are %v, want two random enc/ names", names)
+	}
+
+	st, err := repo.BlobStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Blobs != 2 || st.Saved != 0 {
+		t.Errorf("stats are %+v, want two blobs and nothing saved", st)
+	}
+}
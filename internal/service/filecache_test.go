@@ -0,0 +1,60 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/swmh/gopetbin/internal/server"
+)
+
+func TestFileCacheUpdateDuringRead(t *testing.T) {
+	ctx := context.Background()
+	e := newEnv(t, 0)
+	id, _ := e.create(t, "old", "application/octet-stream")
+
+	// The first read blocks once it reads the old content from storage.
+	var once sync.Once
+
+	reading, proceed := make(chan struct{}), make(chan struct{})
+	e.storage.beforeGet = func() {
+		once.Do(func() {
+			close(reading)
+			<-proceed
+		})
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		if _, err := e.read(server.Query{ID: id}); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// The update lands while the old content is read from storage.
+	<-reading
+	go func() {
+		defer wg.Done()
+
+		if _, err := e.service.UpdatePaste(ctx, id, e.tokens[id], strings.NewReader("new"), 3); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	close(proceed)
+	wg.Wait()
+
+	got, err := e.read(server.Query{ID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "new" {
+		t.Errorf("content is %q after the update, want %q", got, "new")
+	}
+}
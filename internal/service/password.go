@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	l "github.com/swmh/gopetbin/internal/logger"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const attemptsKeyPrefix = "attempts:"
+
+var (
+	errWrongPassword    = errors.New("wrong password")
+	errTooManyAttempts  = errors.New("too many password attempts")
+	errPasswordRequired = fmt.Errorf("password required: %w", errWrongPassword)
+)
+
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("cannot hash password: %w", err)
+	}
+
+	return string(h), nil
+}
+
+func (s *Service) IsWrongPassword(err error) bool {
+	return errors.Is(err, errWrongPassword)
+}
+
+func (s *Service) IsTooManyAttempts(err error) bool {
+	return errors.Is(err, errTooManyAttempts)
+}
+
+// checkPassword verifies password against the paste's password hash. Wrong
+// attempts are counted per paste and once passwordAttempts is reached every
+// attempt is refused until passwordWindow passes.
+func (s *Service) checkPassword(ctx context.Context, id string, paste Paste, password string) error {
+	if paste.Password == "" {
+		return nil
+	}
+
+	if password == "" {
+		return errPasswordRequired
+	}
+
+	key := attemptsKeyPrefix + id
+
+	if s.passwordAttempts > 0 {
+		attempts, err := s.cache.Attempts(ctx, key)
+		if err != nil {
+			s.logger.Warn("Cannot get password attempts", slog.String("key", key), l.ErrorAttr(err))
+		}
+
+		if attempts >= int64(s.passwordAttempts) {
+			return errTooManyAttempts
+		}
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(paste.Password), []byte(password))
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return fmt.Errorf("cannot compare password: %w", err)
+	}
+
+	if s.passwordAttempts > 0 {
+		if err = s.cache.AddAttempt(ctx, key, s.passwordWindow); err != nil {
+			s.logger.Warn("Cannot add password attempt", slog.String("key", key), l.ErrorAttr(err))
+		}
+	}
+
+	return errWrongPassword
+}
@@ -0,0 +1,70 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/cache"
+	"github.com/swmh/gopetbin/internal/server"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+)
+
+func TestPasswordAttempts(t *testing.T) {
+	ctx := context.Background()
+
+	s := servicetest.NewService(t, service.Config{
+		Cache:            cache.NewMemory(100),
+		PasswordAttempts: 3,
+		PasswordWindow:   time.Minute,
+	})
+
+	info, err := s.CreatePaste(ctx, server.Paste{Password: "secret", Content: strings.NewReader("lorem ipsum"), Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = s.StatPaste(ctx, server.Query{ID: info.ID, Password: "guess"}); !s.IsWrongPassword(err) {
+			t.Fatalf("guess %d is %v, want wrong password", i+1, err)
+		}
+	}
+
+	// Once locked even the right password is refused.
+	for _, password := range []string{"guess", "secret"} {
+		if _, err = s.StatPaste(ctx, server.Query{ID: info.ID, Password: password}); !s.IsTooManyAttempts(err) {
+			t.Errorf("%s after the limit is %v, want too many attempts", password, err)
+		}
+	}
+}
+
+func TestPasswordAttemptsConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		window   time.Duration
+		wantErr  bool
+	}{
+		{name: "defaults"},
+		{name: "set", attempts: 3, window: time.Minute},
+		{name: "default window", attempts: 3},
+		{name: "negative window", attempts: 3, window: -time.Minute, wantErr: true},
+		{name: "disabled", attempts: -1, window: -time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.New(service.Config{
+				IDLength:         10,
+				DefaultExpire:    time.Hour,
+				PasswordAttempts: tt.attempts,
+				PasswordWindow:   tt.window,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error is %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,26 +1,49 @@
 package service
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/md5"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"math/rand"
+	"net/http"
+	"slices"
 	"time"
 
 	l "github.com/swmh/gopetbin/internal/logger"
 	"github.com/swmh/gopetbin/internal/server"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/swmh/gopetbin/internal/service")
+
 type Paste struct {
-	Name       string
-	Expire     time.Time
-	BurnAfter  int
-	IsBurnable bool
+	Name        string
+	Expire      time.Time
+	BurnAfter   int
+	IsBurnable  bool
+	DeleteToken string
+	Revision    int
+	Lang        string
+	Password    string
+	Encrypted   bool
+	Owner       int64 /* user id, 0 if anonymous */
+	Size        int64
+	CreatorIP   string
+	ContentType string
+	Filename    string
+	Modified    time.Time /* creation of the current revision */
+	Checksum    string    /* hex SHA-256 of the content, empty if stored before checksums */
 }
 
 type ToReadCloser struct {
@@ -31,7 +54,29 @@ func (t ToReadCloser) Close() error {
 	return nil
 }
 
-var errNoSuchPaste = errors.New("no such paste")
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+var (
+	errNoSuchPaste  = errors.New("no such paste")
+	errInvalidToken = errors.New("invalid delete token")
+	errBlocked      = errors.New("content is blocked")
+	errBadRange     = errors.New("range not satisfiable")
+)
 
 type NoSuchPasteChecker interface {
 	IsNoSuchPaste(err error) bool
@@ -43,26 +88,81 @@ type Cache interface {
 	Get(ctx context.Context, key string) (string, error)
 	IsError(ctx context.Context, value string) bool
 	Unmarshal(ctx context.Context, value string) (Paste, error)
+	Delete(ctx context.Context, key string) error
+	Attempts(ctx context.Context, key string) (int64, error)
+	AddAttempt(ctx context.Context, key string, ttl time.Duration) error
 	NoSuchPasteChecker
 }
 
 type FileCache interface {
 	Set(ctx context.Context, key string, value []byte) error
 	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
 	NoSuchPasteChecker
 }
 
 type Storage interface {
-	PutFile(ctx context.Context, name string, data io.Reader, size int64) error
+	// PutFile stores data, codec is the encoding of data, "" if raw.
+	PutFile(ctx context.Context, name string, data io.Reader, size int64, codec string) error
 	GetFile(ctx context.Context, name string) (io.ReadCloser, error)
+	GetFileRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+	// FileSize returns the number of bytes stored under name.
+	FileSize(ctx context.Context, name string) (int64, error)
+	MoveFile(ctx context.Context, src, dst string) error
+	DeleteFile(ctx context.Context, name string) error
 	IsPasteExist(ctx context.Context, name string) bool
 	NoSuchPasteChecker
 }
 
+// BlobFunc changes the stored content of a blob. The repo calls it while
+// no one else can take or delete the blob, so the cleaner and uploads of
+// the same content wait for it.
+type BlobFunc func(ctx context.Context) error
+
+// BlobStats sums up the deduplication of referenced blobs.
+type BlobStats struct {
+	Blobs      int64
+	References int64
+	Size       int64 /* content bytes stored */
+	Saved      int64 /* content bytes not stored thanks to deduplication */
+}
+
+// Repository counts the references of pastes to blobs. A reference is taken
+// by CreatePaste and UpdatePaste, which call place if the blob was not
+// referenced before, and dropped once the paste is deleted. Burned and
+// expired pastes keep theirs until the cleaner deletes them, reads still
+// streaming their content must not lose it. Unreferenced blobs are removed
+// with DeleteBlob.
 type Repository interface {
-	CreatePaste(ctx context.Context, id string, name string, expire time.Time, burn int) error
+	CreatePaste(ctx context.Context, id string, paste Paste, place BlobFunc) error
 	GetPaste(ctx context.Context, id string) (Paste, error)
-	Readed(ctx context.Context, id string) error
+	// Consume atomically takes one read from a burnable paste and returns
+	// how many are left, it fails with a no such paste error once none remain.
+	// The last read expires the paste.
+	Consume(ctx context.Context, id string) (int, error)
+	UpdatePaste(ctx context.Context, id string, name string, checksum string, size int64, place BlobFunc) (int, error)
+	// GetRevision returns the content name and checksum of the revision.
+	GetRevision(ctx context.Context, id string, revision int) (string, string, error)
+	// DeletePaste returns the blobs no other paste refers to anymore.
+	DeletePaste(ctx context.Context, id string) ([]string, error)
+	// DeleteBlob calls remove and forgets the blob if it is still not
+	// referenced, it fails with a no such paste error otherwise.
+	DeleteBlob(ctx context.Context, name string, remove BlobFunc) error
+	BlobStats(ctx context.Context) (BlobStats, error)
+	GetUserByKey(ctx context.Context, keyHash string) (User, error)
+	ListPastes(ctx context.Context, owner int64, limit, offset int) ([]ListedPaste, error)
+	FindPastes(ctx context.Context, f PasteFilter) ([]ListedPaste, error)
+	BlockHash(ctx context.Context, hash string, reason string) error
+	UnblockHash(ctx context.Context, hash string) error
+	IsBlocked(ctx context.Context, hash string) (bool, error)
+	// LegacyBlobs returns up to limit content names referred to without a
+	// checksum, ordered by name after the given one.
+	LegacyBlobs(ctx context.Context, after string, limit int) ([]string, error)
+	// RenameBlob points every paste and revision having the old name at name
+	// with checksum and moves the references over, place is called like on
+	// CreatePaste. It returns the ids of pastes whose latest revision changed.
+	RenameBlob(ctx context.Context, old, name, checksum string, place BlobFunc) ([]string, error)
 	NoSuchPasteChecker
 }
 
@@ -74,6 +174,31 @@ type Locker interface {
 	Lock(ctx context.Context, id string) (Mutex, error)
 }
 
+// Paste events reported to Metrics.
+const (
+	EventCreate = "create"
+	EventRead   = "read"
+	EventBurn   = "burn"
+	EventExpire = "expire" /* reported by the cleaner as it deletes pastes */
+)
+
+type Metrics interface {
+	PasteEvent(event string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) PasteEvent(string) {}
+
+// defaultFileCacheMaxSize matches the default max paste size, so every paste
+// is cached unless configured otherwise.
+const defaultFileCacheMaxSize = 10 << 20
+
+const (
+	defaultPasswordAttempts = 5
+	defaultPasswordWindow   = 15 * time.Minute
+)
+
 type Config struct {
 	Storage   Storage
 	Repo      Repository
@@ -81,9 +206,13 @@ type Config struct {
 	FileCache FileCache
 	Locker    Locker
 	Logger    *slog.Logger
+	Metrics   Metrics /* optional */
 
-	IDLength      int
-	DefaultExpire time.Duration
+	IDLength         int
+	DefaultExpire    time.Duration
+	FileCacheMaxSize int64         /* 0 uses the default, negative disables the file cache */
+	PasswordAttempts int           /* 0 uses the default, negative disables the limit */
+	PasswordWindow   time.Duration /* 0 uses the default */
 }
 
 type Service struct {
@@ -93,9 +222,13 @@ type Service struct {
 	fileCache FileCache
 	locker    Locker
 	logger    *slog.Logger
+	metrics   Metrics
 
-	idLength      int
-	defaultExpire time.Duration
+	idLength         int
+	defaultExpire    time.Duration
+	fileCacheMaxSize int64
+	passwordAttempts int
+	passwordWindow   time.Duration
 }
 
 func New(c Config) (*Service, error) {
@@ -107,15 +240,40 @@ func New(c Config) (*Service, error) {
 		return nil, errors.New("default expire must be >= 0")
 	}
 
+	if c.Metrics == nil {
+		c.Metrics = nopMetrics{}
+	}
+
+	if c.FileCacheMaxSize == 0 {
+		c.FileCacheMaxSize = defaultFileCacheMaxSize
+	}
+
+	if c.PasswordAttempts == 0 {
+		c.PasswordAttempts = defaultPasswordAttempts
+	}
+
+	if c.PasswordWindow == 0 {
+		c.PasswordWindow = defaultPasswordWindow
+	}
+
+	// Counters without a window expire at once, the limit would never apply.
+	if c.PasswordAttempts > 0 && c.PasswordWindow <= 0 {
+		return nil, errors.New("password window must be > 0")
+	}
+
 	return &Service{
-		storage:       c.Storage,
-		repo:          c.Repo,
-		cache:         c.Cache,
-		fileCache:     c.FileCache,
-		locker:        c.Locker,
-		logger:        c.Logger,
-		idLength:      c.IDLength,
-		defaultExpire: c.DefaultExpire,
+		storage:          c.Storage,
+		repo:             c.Repo,
+		cache:            c.Cache,
+		fileCache:        c.FileCache,
+		locker:           c.Locker,
+		logger:           c.Logger,
+		metrics:          c.Metrics,
+		idLength:         c.IDLength,
+		defaultExpire:    c.DefaultExpire,
+		fileCacheMaxSize: c.FileCacheMaxSize,
+		passwordAttempts: c.PasswordAttempts,
+		passwordWindow:   c.PasswordWindow,
 	}, nil
 }
 
@@ -130,11 +288,40 @@ func (s *Service) getID() string {
 	return string(b)
 }
 
-func getName(data []byte) string {
-	h := md5.Sum(data)
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func newDeleteToken() (string, error) {
+	return randomHex(16)
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(h[:])
 }
 
+func (s *Service) IsBlocked(err error) bool {
+	return errors.Is(err, errBlocked)
+}
+
+func (s *Service) IsChecksumMismatch(err error) bool {
+	return errors.Is(err, errChecksum)
+}
+
+func (s *Service) IsRangeNotSatisfiable(err error) bool {
+	return errors.Is(err, errBadRange)
+}
+
+func (s *Service) IsInvalidToken(err error) bool {
+	return errors.Is(err, errInvalidToken)
+}
+
 func (s *Service) IsNoSuchPaste(err error) bool {
 	return s.repo.IsNoSuchPaste(err) ||
 		s.storage.IsNoSuchPaste(err) ||
@@ -143,22 +330,17 @@ func (s *Service) IsNoSuchPaste(err error) bool {
 		errors.Is(err, errNoSuchPaste)
 }
 
-func (s *Service) getPaste(ctx context.Context, id string) (Paste, error) {
-	mutex, err := s.locker.Lock(ctx, id)
-	if err != nil {
-		return Paste{}, fmt.Errorf("cannot acquire lock: %w", err)
-	}
-
-	defer func() {
-		if err = mutex.Unlock(ctx); err != nil {
-			s.logger.Error("Cannot unlock", l.ErrorAttr(err))
-		}
-	}()
+// lookupPaste returns a readable paste, checking its expiry and, unless
+// authorized already, its password but without taking a read from it.
+func (s *Service) lookupPaste(ctx context.Context, id string, password string, authorized bool) (Paste, error) {
+	span := trace.SpanFromContext(ctx)
 
 	var paste Paste
 
 	value, err := s.cache.Get(ctx, id)
 	if err == nil {
+		span.AddEvent("cache hit")
+
 		if s.cache.IsError(ctx, value) {
 			return Paste{}, errNoSuchPaste
 		}
@@ -181,18 +363,48 @@ func (s *Service) getPaste(ctx context.Context, id string) (Paste, error) {
 		return paste, fmt.Errorf("paste expired: %w", errNoSuchPaste)
 	}
 
-	if paste.IsBurnable {
-		if paste.BurnAfter <= 0 {
-			return paste, fmt.Errorf("paste already burned: %w", errNoSuchPaste)
+	if !authorized {
+		if err = s.checkPassword(ctx, id, paste, password); err != nil {
+			return paste, err
 		}
+	}
+
+	if paste.IsBurnable && paste.BurnAfter <= 0 {
+		return paste, fmt.Errorf("paste already burned: %w", errNoSuchPaste)
+	}
 
-		if err = s.repo.Readed(ctx, id); err != nil {
-			return paste, fmt.Errorf("cannot read paste in repo: %w", err)
+	return paste, nil
+}
+
+// getPaste takes a read from the paste, the caller holds its lock.
+func (s *Service) getPaste(ctx context.Context, id string, password string, authorized bool) (Paste, error) {
+	ctx, span := tracer.Start(ctx, "Service.getPaste", trace.WithAttributes(attribute.String("paste.id", id)))
+	defer span.End()
+
+	paste, err := s.lookupPaste(ctx, id, password, authorized)
+	if err != nil {
+		return paste, err
+	}
+
+	if paste.IsBurnable {
+		// The cached counter may be stale, the repo has the final word.
+		paste.BurnAfter, err = s.repo.Consume(ctx, id)
+		if err != nil {
+			if s.repo.IsNoSuchPaste(err) {
+				s.invalidate(ctx, id)
+				return paste, fmt.Errorf("paste already burned: %w", errNoSuchPaste)
+			}
+
+			return paste, fmt.Errorf("cannot consume paste in repo: %w", err)
 		}
 
-		paste.BurnAfter--
+		if paste.BurnAfter == 0 {
+			s.metrics.PasteEvent(EventBurn)
+		}
 	}
 
+	s.metrics.PasteEvent(EventRead)
+
 	err = s.cache.Set(ctx, id, paste)
 	if err != nil {
 		s.logger.Warn("Cannot set value in cache", slog.String("key", id), l.ErrorAttr(err))
@@ -201,58 +413,646 @@ func (s *Service) getPaste(ctx context.Context, id string) (Paste, error) {
 	return paste, nil
 }
 
-func (s *Service) GetPaste(ctx context.Context, id string) (io.ReadCloser, error) {
-	paste, err := s.getPaste(ctx, id)
+// GetPaste keeps the paste locked until its content is fetched, so the file
+// cache is never filled with content an update or delete has replaced in
+// the meantime. Streaming the content happens after the lock is released.
+func (s *Service) GetPaste(ctx context.Context, q server.Query) (server.PasteInfo, io.ReadCloser, error) {
+	ctx, span := tracer.Start(ctx, "Service.GetPaste", trace.WithAttributes(attribute.String("paste.id", q.ID)))
+	defer span.End()
+
+	id := q.ID
+
+	mutex, err := s.locker.Lock(ctx, id)
+	if err != nil {
+		return server.PasteInfo{}, nil, fmt.Errorf("cannot acquire lock: %w", err)
+	}
+
+	defer func() {
+		if err := mutex.Unlock(ctx); err != nil {
+			s.logger.Error("Cannot unlock", l.ErrorAttr(err))
+		}
+	}()
+
+	paste, err := s.getPaste(ctx, id, q.Password, q.Authorized)
 	if err != nil {
 		if s.IsNoSuchPaste(err) {
 			if cerr := s.cache.SetError(ctx, id, time.Hour); cerr != nil {
-				s.logger.Warn("Cannot set error in cache", slog.String("key", id), l.ErrorAttr(err))
+				s.logger.Warn("Cannot set error in cache", slog.String("key", id), l.ErrorAttr(cerr))
 			}
 		}
 
-		return nil, err
+		return server.PasteInfo{}, nil, err
+	}
+
+	info := newInfo(id, paste)
+	name, checksum := paste.Name, paste.Checksum
+	latest := true
+
+	if q.Revision > 0 && q.Revision != paste.Revision {
+		if name, checksum, err = s.revisionInfo(ctx, &info, q.Revision); err != nil {
+			return server.PasteInfo{}, nil, err
+		}
+
+		latest = false
+	}
+
+	codec := codecOf(name)
+
+	if rangeApplies(q, paste, info) {
+		offset, length, ok := q.Range.Resolve(info.Size)
+		if !ok {
+			return info, nil, errBadRange
+		}
+
+		info.Partial, info.Offset, info.Length = true, offset, length
+
+		file, err := s.getRange(ctx, id, name, offset, length)
+		if err != nil {
+			return server.PasteInfo{}, nil, err
+		}
+
+		return info, file, nil
+	}
+
+	blob, verified, err := s.getBlob(ctx, id, name, checksum, latest)
+	if err != nil {
+		return server.PasteInfo{}, nil, err
+	}
+
+	if verified {
+		checksum = ""
+	}
+
+	if info.Encoding = encoding(q, codec); info.Encoding != "" {
+		return info, s.verify(ctx, id, name, blob, codec, checksum), nil
+	}
+
+	file, err := decode(blob, codec)
+	if err != nil {
+		return server.PasteInfo{}, nil, err
+	}
+
+	return info, s.verify(ctx, id, name, file, "", checksum), nil
+}
+
+// newInfo describes the latest revision of the paste.
+func newInfo(id string, paste Paste) server.PasteInfo {
+	return server.PasteInfo{
+		ID:          id,
+		Expire:      paste.Expire,
+		BurnAfter:   paste.BurnAfter,
+		IsBurnable:  paste.IsBurnable,
+		Size:        paste.Size,
+		Revision:    paste.Revision,
+		Lang:        paste.Lang,
+		Encrypted:   paste.Encrypted,
+		ContentType: paste.ContentType,
+		Filename:    paste.Filename,
+		Hash:        contentHash(paste.Name),
+		Modified:    paste.Modified,
+		Protected:   paste.Password != "",
+	}
+}
+
+// revisionInfo points info at an older revision of the paste and returns
+// its blob name and checksum. Only the content of older revisions is known.
+func (s *Service) revisionInfo(ctx context.Context, info *server.PasteInfo, revision int) (string, string, error) {
+	name, checksum, err := s.repo.GetRevision(ctx, info.ID, revision)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot get revision from repo: %w", err)
+	}
+
+	info.Revision = revision
+	info.Hash = contentHash(name)
+	info.Size = 0
+	info.Modified = time.Time{}
+
+	return name, checksum, nil
+}
+
+// rangeApplies tells whether a range of the paste is served. Burnable
+// pastes ignore ranges, a read is taken per request and a partial one
+// would leave the rest unreadable. The size of older revisions and of
+// pastes created before it was recorded is unknown.
+func rangeApplies(q server.Query, paste Paste, info server.PasteInfo) bool {
+	return q.Range != nil && !paste.IsBurnable && info.Size > 0
+}
+
+// encoding is the codec to serve the blob in as is, "" if the client
+// does not accept it and the blob must be decoded.
+func encoding(q server.Query, codec string) string {
+	if codec != "" && slices.Contains(q.Encodings, codec) {
+		return codec
 	}
 
-	var file io.ReadCloser
+	return ""
+}
+
+// StatPaste describes the paste like GetPaste does, but takes no read from
+// burnable pastes and does not fetch the content.
+func (s *Service) StatPaste(ctx context.Context, q server.Query) (server.PasteInfo, error) {
+	ctx, span := tracer.Start(ctx, "Service.StatPaste", trace.WithAttributes(attribute.String("paste.id", q.ID)))
+	defer span.End()
 
-	file, err = s.fileCache.Get(ctx, id)
+	paste, err := s.lookupPaste(ctx, q.ID, q.Password, q.Authorized)
 	if err != nil {
-		if !s.fileCache.IsNoSuchPaste(err) {
+		return server.PasteInfo{}, err
+	}
+
+	info := newInfo(q.ID, paste)
+	name := paste.Name
+
+	if q.Revision > 0 && q.Revision != paste.Revision {
+		if name, _, err = s.revisionInfo(ctx, &info, q.Revision); err != nil {
+			return server.PasteInfo{}, err
+		}
+	}
+
+	if !rangeApplies(q, paste, info) {
+		info.Encoding = encoding(q, codecOf(name))
+	}
+
+	return info, nil
+}
+
+// getBlob streams the stored bytes of the blob. Only the latest revision
+// goes through the file cache. Blobs small enough for it are read whole and
+// checked against checksum before they are returned, so a mismatch fails
+// the read before anything is sent, verified reports whether that happened.
+func (s *Service) getBlob(ctx context.Context, id, name, checksum string, latest bool) (_ io.ReadCloser, verified bool, _ error) {
+	codec := codecOf(name)
+
+	if latest {
+		file, err := s.fileCache.Get(ctx, id)
+		if err == nil {
+			data, err := io.ReadAll(file)
+			file.Close()
+
+			if err == nil {
+				if err = check(data, codec, checksum); err == nil {
+					return ToReadCloser{bytes.NewReader(data)}, true, nil
+				}
+
+				s.mismatch(ctx, id, name)
+			} else {
+				s.logger.Warn("Cannot read value from file cache", slog.String("key", id), l.ErrorAttr(err))
+			}
+		} else if !s.fileCache.IsNoSuchPaste(err) {
 			s.logger.Warn("Cannot get value from file cache", slog.String("key", id), l.ErrorAttr(err))
 		}
+	}
 
-		file, err = s.storage.GetFile(ctx, paste.Name)
+	file, err := s.storage.GetFile(ctx, name)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot get paste from storage: %w", err)
+	}
+
+	if !latest {
+		return file, false, nil
+	}
+
+	return s.fillFileCache(ctx, id, name, checksum, file)
+}
+
+// getRange streams part of the latest revision. Ranges of raw blobs are
+// read directly from the file cache or storage and never fill the cache,
+// compressed blobs have to be decoded up to the offset. Ranges cannot be
+// checked against the checksum.
+func (s *Service) getRange(ctx context.Context, id, name string, offset, length int64) (io.ReadCloser, error) {
+	if codec := codecOf(name); codec != "" {
+		blob, _, err := s.getBlob(ctx, id, name, "", true)
 		if err != nil {
-			return nil, fmt.Errorf("cannot get paste from storage: %w", err)
+			return nil, err
 		}
 
-		data, err := io.ReadAll(file)
+		file, err := decode(blob, codec)
 		if err != nil {
-			return nil, fmt.Errorf("cannot read file: %w", err)
+			return nil, err
 		}
 
-		file = ToReadCloser{bytes.NewReader(data)}
+		return section(file, offset, length)
+	}
+
+	file, err := s.fileCache.GetRange(ctx, id, offset, length)
+	if err == nil {
+		return file, nil
+	}
+
+	if !s.fileCache.IsNoSuchPaste(err) {
+		s.logger.Warn("Cannot get value from file cache", slog.String("key", id), l.ErrorAttr(err))
+	}
+
+	file, err = s.storage.GetFileRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get paste from storage: %w", err)
+	}
+
+	return file, nil
+}
+
+// fillFileCache caches file if it is not larger than fileCacheMaxSize.
+// At most fileCacheMaxSize bytes are buffered, larger files are streamed
+// to the caller as is. The cache holds blobs as stored, compressed ones
+// stay compressed. Buffered files are checked first, a mismatch is never
+// cached.
+func (s *Service) fillFileCache(ctx context.Context, id, name, checksum string, file io.ReadCloser) (io.ReadCloser, bool, error) {
+	if s.fileCacheMaxSize <= 0 {
+		return file, false, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, s.fileCacheMaxSize+1))
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("cannot read file: %w", err)
+	}
 
-		err = s.fileCache.Set(ctx, id, data)
+	if int64(len(data)) > s.fileCacheMaxSize {
+		return readCloser{io.MultiReader(bytes.NewReader(data), file), file}, false, nil
+	}
+
+	file.Close()
+
+	if err = check(data, codecOf(name), checksum); err != nil {
+		s.mismatch(ctx, id, name)
+		return nil, false, err
+	}
+
+	err = s.fileCache.Set(ctx, id, data)
+	if err != nil {
+		s.logger.Warn("Cannot set value in file cache", slog.String("key", id), l.ErrorAttr(err))
+	}
+
+	return ToReadCloser{bytes.NewReader(data)}, true, nil
+}
+
+// blob is content uploaded under a temporary name, it is moved to its name
+// once a paste takes the first reference to it.
+type blob struct {
+	name     string
+	checksum string
+	size     int64
+	tmp      string
+	placed   bool
+}
+
+// place moves the upload to its name, an existing blob of the same content
+// is replaced.
+func (s *Service) place(b *blob) BlobFunc {
+	return func(ctx context.Context) error {
+		if err := s.storage.MoveFile(ctx, b.tmp, b.name); err != nil {
+			return fmt.Errorf("cannot move file: %w", err)
+		}
+
+		b.placed = true
+
+		return nil
+	}
+}
+
+// discard deletes the upload unless it was placed.
+func (s *Service) discard(ctx context.Context, b *blob) {
+	if b.placed {
+		return
+	}
+
+	if err := s.storage.DeleteFile(ctx, b.tmp); err != nil {
+		s.logger.Warn("Cannot delete temporary file", slog.String("name", b.tmp), l.ErrorAttr(err))
+	}
+}
+
+func tmpName() (string, error) {
+	name, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate temporary name: %w", err)
+	}
+
+	return "tmp/" + name, nil
+}
+
+// putContent streams content to storage under a temporary name while
+// hashing it. Compressible content is stored zstd encoded if its head
+// shrinks, the hash is of the content itself. Only content longer than the
+// head is uploaded without knowing its stored size. Blocked content is
+// dropped.
+func (s *Service) putContent(ctx context.Context, content io.Reader, size int64, ct string) (blob, error) {
+	tmp, err := tmpName()
+	if err != nil {
+		return blob{}, err
+	}
+
+	// Blocks placed before content was named by SHA-256 are on md5 hashes.
+	h, legacy := newHash(), md5.New()
+	r := &countingReader{r: io.TeeReader(content, io.MultiWriter(h, legacy))}
+
+	var codec string
+	var body io.Reader = r
+
+	if compressible(ct) {
+		head, err := io.ReadAll(io.LimitReader(r, sampleSize))
 		if err != nil {
-			s.logger.Warn("Cannot set value in file cache", slog.String("key", id), l.ErrorAttr(err))
+			return blob{}, fmt.Errorf("cannot read content: %w", err)
+		}
+
+		body = io.MultiReader(bytes.NewReader(head), r)
+
+		enc, ok := shrink(head)
+
+		switch {
+		case !ok:
+		case len(head) < sampleSize:
+			// The head is all the content, its encoding is known whole.
+			codec, body, size = CodecZstd, bytes.NewReader(enc), int64(len(enc))
+		default:
+			c := compress(body)
+			defer c.Close()
+
+			codec, body, size = CodecZstd, c, -1
 		}
 	}
 
-	return file, nil
+	if err = s.storage.PutFile(ctx, tmp, body, size, codec); err != nil {
+		return blob{}, err
+	}
+
+	b := blob{checksum: getName(h), size: r.n, tmp: tmp}
+
+	blocked, err := s.isBlocked(ctx, b.checksum, getName(legacy))
+	if err != nil || blocked {
+		s.discard(ctx, &b)
+
+		if err != nil {
+			return blob{}, fmt.Errorf("cannot check block in repo: %w", err)
+		}
+
+		return blob{}, errBlocked
+	}
+
+	b.name = b.checksum
+	if codec == CodecZstd {
+		b.name += zstdExt
+	}
+
+	return b, nil
 }
 
-func (s *Service) CreatePaste(ctx context.Context, paste server.Paste) (string, error) {
-	name := getName(paste.Content)
-	if !s.storage.IsPasteExist(ctx, name) {
-		err := s.storage.PutFile(ctx, name, bytes.NewReader(paste.Content), int64(len(paste.Content)))
+func (s *Service) isBlocked(ctx context.Context, hashes ...string) (bool, error) {
+	for _, hash := range hashes {
+		if blocked, err := s.repo.IsBlocked(ctx, hash); err != nil || blocked {
+			return blocked, err
+		}
+	}
+
+	return false, nil
+}
+
+// putEncrypted stores content under a random name. Encrypted pastes are
+// not deduplicated since a shared name would reveal equal ciphertexts,
+// and not compressed since ciphertext does not compress.
+func (s *Service) putEncrypted(ctx context.Context, content io.Reader, size int64) (blob, error) {
+	name, err := randomHex(16)
+	if err != nil {
+		return blob{}, fmt.Errorf("cannot generate name: %w", err)
+	}
+
+	tmp, err := tmpName()
+	if err != nil {
+		return blob{}, err
+	}
+
+	h := newHash()
+	r := &countingReader{r: io.TeeReader(content, h)}
+
+	if err = s.storage.PutFile(ctx, tmp, r, size, ""); err != nil {
+		return blob{}, err
+	}
+
+	return blob{name: encPrefix + name, checksum: getName(h), size: r.n, tmp: tmp}, nil
+}
+
+func (s *Service) put(ctx context.Context, content io.Reader, size int64, ct string, encrypted bool) (blob, error) {
+	// Storage buffers uploads of unknown size a part at a time, content
+	// shorter than the sample is read first so its size is known.
+	if size < 0 {
+		head, err := io.ReadAll(io.LimitReader(content, sampleSize))
 		if err != nil {
-			return "", err
+			return blob{}, fmt.Errorf("cannot read content: %w", err)
+		}
+
+		content = io.MultiReader(bytes.NewReader(head), content)
+
+		if len(head) < sampleSize {
+			size = int64(len(head))
 		}
 	}
 
-	t := time.Now().UTC().Add(paste.Expire)
+	if encrypted {
+		return s.putEncrypted(ctx, content, size)
+	}
+
+	return s.putContent(ctx, content, size, ct)
+}
+
+// sniffLength is how much content http.DetectContentType looks at.
+const sniffLength = 512
+
+// contentType returns the type of the content, sniffing it if the client
+// did not declare one. Ciphertext is opaque to the server.
+func contentType(content io.Reader, declared string, encrypted bool) (io.Reader, string, error) {
+	if encrypted {
+		return content, "application/octet-stream", nil
+	}
+
+	if declared != "" {
+		return content, declared, nil
+	}
+
+	br := bufio.NewReaderSize(content, sniffLength)
+
+	head, err := br.Peek(sniffLength)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, "", fmt.Errorf("cannot read content: %w", err)
+	}
+
+	return br, http.DetectContentType(head), nil
+}
+
+func (s *Service) CreatePaste(ctx context.Context, paste server.Paste) (server.PasteInfo, error) {
+	ctx, span := tracer.Start(ctx, "Service.CreatePaste")
+	defer span.End()
+
+	content, ct, err := contentType(paste.Content, paste.ContentType, paste.Encrypted)
+	if err != nil {
+		return server.PasteInfo{}, err
+	}
+
+	if paste.Expire == 0 {
+		paste.Expire = s.defaultExpire
+	}
+
+	token, err := newDeleteToken()
+	if err != nil {
+		return server.PasteInfo{}, fmt.Errorf("cannot generate delete token: %w", err)
+	}
+
+	password, err := hashPassword(paste.Password)
+	if err != nil {
+		return server.PasteInfo{}, err
+	}
+
+	b, err := s.put(ctx, content, paste.Size, ct, paste.Encrypted)
+	if err != nil {
+		return server.PasteInfo{}, err
+	}
+	defer s.discard(ctx, &b)
+
+	p := Paste{
+		Name:        b.name,
+		Expire:      time.Now().UTC().Add(paste.Expire),
+		BurnAfter:   paste.BurnAfter,
+		IsBurnable:  paste.BurnAfter > 0,
+		DeleteToken: hashToken(token),
+		Revision:    1,
+		Lang:        paste.Lang,
+		Password:    password,
+		Encrypted:   paste.Encrypted,
+		Owner:       paste.Owner,
+		Size:        b.size,
+		CreatorIP:   paste.CreatorIP,
+		ContentType: ct,
+		Filename:    paste.Filename,
+		Checksum:    b.checksum,
+	}
 	id := s.getID()
+	span.SetAttributes(attribute.String("paste.id", id))
+
+	if err = s.repo.CreatePaste(ctx, id, p, s.place(&b)); err != nil {
+		return server.PasteInfo{}, err
+	}
 
-	return id, s.repo.CreatePaste(ctx, id, name, t, paste.BurnAfter)
+	s.metrics.PasteEvent(EventCreate)
+
+	return server.PasteInfo{
+		ID:          id,
+		Expire:      p.Expire,
+		BurnAfter:   p.BurnAfter,
+		IsBurnable:  p.IsBurnable,
+		Size:        b.size,
+		Revision:    p.Revision,
+		Lang:        p.Lang,
+		Encrypted:   p.Encrypted,
+		DeleteToken: token,
+		ContentType: p.ContentType,
+		Filename:    p.Filename,
+	}, nil
+}
+
+// UpdatePaste stores content as a new revision of the paste if token
+// matches the one issued on creation.
+func (s *Service) UpdatePaste(ctx context.Context, id string, token string, content io.Reader, size int64) (server.PasteInfo, error) {
+	ctx, span := tracer.Start(ctx, "Service.UpdatePaste", trace.WithAttributes(attribute.String("paste.id", id)))
+	defer span.End()
+
+	mutex, err := s.locker.Lock(ctx, id)
+	if err != nil {
+		return server.PasteInfo{}, fmt.Errorf("cannot acquire lock: %w", err)
+	}
+
+	defer func() {
+		if err = mutex.Unlock(ctx); err != nil {
+			s.logger.Error("Cannot unlock", l.ErrorAttr(err))
+		}
+	}()
+
+	paste, err := s.repo.GetPaste(ctx, id)
+	if err != nil {
+		return server.PasteInfo{}, fmt.Errorf("cannot get paste from repo: %w", err)
+	}
+
+	if !checkToken(paste, token) {
+		return server.PasteInfo{}, errInvalidToken
+	}
+
+	if time.Now().UTC().After(paste.Expire) {
+		return server.PasteInfo{}, fmt.Errorf("paste expired: %w", errNoSuchPaste)
+	}
+
+	if paste.IsBurnable && paste.BurnAfter <= 0 {
+		return server.PasteInfo{}, fmt.Errorf("paste already burned: %w", errNoSuchPaste)
+	}
+
+	b, err := s.put(ctx, content, size, paste.ContentType, paste.Encrypted)
+	if err != nil {
+		return server.PasteInfo{}, err
+	}
+	defer s.discard(ctx, &b)
+
+	revision, err := s.repo.UpdatePaste(ctx, id, b.name, b.checksum, b.size, s.place(&b))
+	if err != nil {
+		return server.PasteInfo{}, fmt.Errorf("cannot update paste in repo: %w", err)
+	}
+
+	s.invalidate(ctx, id)
+
+	return server.PasteInfo{
+		ID:          id,
+		Expire:      paste.Expire,
+		BurnAfter:   paste.BurnAfter,
+		IsBurnable:  paste.IsBurnable,
+		Size:        b.size,
+		Revision:    revision,
+		Lang:        paste.Lang,
+		Encrypted:   paste.Encrypted,
+		ContentType: paste.ContentType,
+		Filename:    paste.Filename,
+	}, nil
+}
+
+func checkToken(paste Paste, token string) bool {
+	return paste.DeleteToken != "" &&
+		subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(paste.DeleteToken)) == 1
+}
+
+func (s *Service) invalidate(ctx context.Context, id string) {
+	if err := s.cache.Delete(ctx, id); err != nil {
+		s.logger.Warn("Cannot delete value from cache", slog.String("key", id), l.ErrorAttr(err))
+	}
+
+	if err := s.fileCache.Delete(ctx, id); err != nil {
+		s.logger.Warn("Cannot delete value from file cache", slog.String("key", id), l.ErrorAttr(err))
+	}
+}
+
+// DeletePaste removes the paste if token matches the one issued on creation.
+// The content itself is reclaimed later by the cleaner.
+func (s *Service) DeletePaste(ctx context.Context, id string, token string) error {
+	ctx, span := tracer.Start(ctx, "Service.DeletePaste", trace.WithAttributes(attribute.String("paste.id", id)))
+	defer span.End()
+
+	mutex, err := s.locker.Lock(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot acquire lock: %w", err)
+	}
+
+	defer func() {
+		if err = mutex.Unlock(ctx); err != nil {
+			s.logger.Error("Cannot unlock", l.ErrorAttr(err))
+		}
+	}()
+
+	paste, err := s.repo.GetPaste(ctx, id)
+	if err != nil {
+		return fmt.Errorf("cannot get paste from repo: %w", err)
+	}
+
+	if !checkToken(paste, token) {
+		return errInvalidToken
+	}
+
+	if _, err = s.repo.DeletePaste(ctx, id); err != nil {
+		return fmt.Errorf("cannot delete paste from repo: %w", err)
+	}
+
+	s.invalidate(ctx, id)
+
+	return nil
 }
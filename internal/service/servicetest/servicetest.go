@@ -0,0 +1,162 @@
+// Package servicetest provides in-memory fakes of the service dependencies
+// for tests.
+package servicetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/db/sqlite"
+	"github.com/swmh/gopetbin/internal/lock/mapmutex"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+)
+
+var errMiss = errors.New("miss")
+
+// Cache never holds a paste, so every read goes to the repo.
+type Cache struct{}
+
+func (Cache) Set(context.Context, string, service.Paste) error      { return nil }
+func (Cache) SetError(context.Context, string, time.Duration) error { return nil }
+func (Cache) Get(context.Context, string) (string, error)           { return "", errMiss }
+func (Cache) IsError(context.Context, string) bool                  { return false }
+func (Cache) Delete(context.Context, string) error                  { return nil }
+func (Cache) IsNoSuchPaste(err error) bool                          { return errors.Is(err, errMiss) }
+
+func (Cache) Unmarshal(context.Context, string) (service.Paste, error) {
+	return service.Paste{}, errMiss
+}
+
+func (Cache) Attempts(context.Context, string) (int64, error) {
+	return 0, nil
+}
+
+func (Cache) AddAttempt(context.Context, string, time.Duration) error {
+	return nil
+}
+
+// FileCache keeps content in a map, ranges always miss.
+type FileCache struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func NewFileCache() *FileCache {
+	return &FileCache{values: make(map[string][]byte)}
+}
+
+func (c *FileCache) Set(_ context.Context, key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] = value
+
+	return nil
+}
+
+func (c *FileCache) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.values[key]
+	if !ok {
+		return nil, errMiss
+	}
+
+	return io.NopCloser(bytes.NewReader(v)), nil
+}
+
+func (c *FileCache) GetRange(context.Context, string, int64, int64) (io.ReadCloser, error) {
+	return nil, errMiss
+}
+
+func (c *FileCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.values, key)
+
+	return nil
+}
+
+func (c *FileCache) IsNoSuchPaste(err error) bool {
+	return errors.Is(err, errMiss)
+}
+
+// NewRepo returns a migrated SQLite repo kept in memory.
+func NewRepo(t *testing.T) *sqlite.DB {
+	t.Helper()
+
+	repo, err := sqlite.New(sqlite.Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := repo.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+// Logger discards everything.
+func Logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// NewService creates a service on c. Unset dependencies are filled with
+// the fakes of this package, memory storage and a repo from NewRepo.
+func NewService(t *testing.T, c service.Config) *service.Service {
+	t.Helper()
+
+	if c.Storage == nil {
+		c.Storage = memory.New()
+	}
+
+	if c.Repo == nil {
+		c.Repo = NewRepo(t)
+	}
+
+	if c.Cache == nil {
+		c.Cache = Cache{}
+	}
+
+	if c.FileCache == nil {
+		c.FileCache = NewFileCache()
+	}
+
+	if c.Locker == nil {
+		c.Locker = mapmutex.New[string]()
+	}
+
+	if c.Logger == nil {
+		c.Logger = Logger()
+	}
+
+	if c.IDLength == 0 {
+		c.IDLength = 8
+	}
+
+	if c.DefaultExpire == 0 {
+		c.DefaultExpire = time.Hour
+	}
+
+	s, err := service.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
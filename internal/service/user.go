@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/swmh/gopetbin/internal/server"
+)
+
+type User struct {
+	ID    int64
+	Name  string
+	Admin bool
+}
+
+// ListedPaste is a paste as returned by Repository.ListPastes.
+type ListedPaste struct {
+	Paste
+	ID      string
+	Created time.Time
+}
+
+var errUnauthorized = errors.New("invalid api key")
+
+// NewAPIKey generates a key to hand out to a user and the hash of it to
+// store, the key itself is never stored.
+func NewAPIKey() (string, string, error) {
+	key, err := randomHex(32)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot generate api key: %w", err)
+	}
+
+	return key, hashToken(key), nil
+}
+
+func (s *Service) IsUnauthorized(err error) bool {
+	return errors.Is(err, errUnauthorized)
+}
+
+func (s *Service) Authenticate(ctx context.Context, key string) (server.User, error) {
+	user, err := s.repo.GetUserByKey(ctx, hashToken(key))
+	if err != nil {
+		if s.repo.IsNoSuchPaste(err) {
+			return server.User{}, errUnauthorized
+		}
+
+		return server.User{}, fmt.Errorf("cannot get user from repo: %w", err)
+	}
+
+	return server.User(user), nil
+}
+
+// ListPastes returns live pastes of the user, newest first.
+func (s *Service) ListPastes(ctx context.Context, user server.User, limit, offset int) ([]server.PasteInfo, error) {
+	pastes, err := s.repo.ListPastes(ctx, user.ID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pastes in repo: %w", err)
+	}
+
+	infos := make([]server.PasteInfo, 0, len(pastes))
+
+	for _, p := range pastes {
+		infos = append(infos, server.PasteInfo{
+			ID:         p.ID,
+			Expire:     p.Expire,
+			BurnAfter:  p.BurnAfter,
+			IsBurnable: p.IsBurnable,
+			Revision:   p.Revision,
+			Lang:       p.Lang,
+			Encrypted:  p.Encrypted,
+			Created:    p.Created,
+		})
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/storage/fs"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+)
+
+const (
+	DriverMinio  = "minio"
+	DriverFS     = "fs"
+	DriverMemory = "memory"
+)
+
+// Driver is implemented by every storage driver.
+type Driver interface {
+	service.Storage
+	Ping(ctx context.Context) error
+}
+
+// Open creates the storage selected by c.Driver, MinIO is used by default.
+func Open(c Config) (Driver, error) {
+	switch c.Driver {
+	case "", DriverMinio:
+		return New(c)
+	case DriverFS:
+		return fs.New(c.Path)
+	case DriverMemory:
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", c.Driver)
+	}
+}
@@ -0,0 +1,190 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tmpDir = ".tmp"
+
+var errBadName = errors.New("bad file name")
+
+// Storage keeps files in a directory tree sharded by the first characters
+// of the file name. Files are written to a temporary file first and renamed
+// into place so readers never see partial content.
+type Storage struct {
+	root string
+}
+
+func New(root string) (*Storage, error) {
+	if root == "" {
+		return nil, errors.New("root must be set")
+	}
+
+	err := os.MkdirAll(filepath.Join(root, tmpDir), 0o750)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create storage directory: %w", err)
+	}
+
+	return &Storage{
+		root: root,
+	}, nil
+}
+
+// path maps name to root/dir/ab/cd/abcd... where dir is the optional
+// directory part of name.
+func (s *Storage) path(name string) (string, error) {
+	if name == "" || strings.Contains(name, "..") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q", errBadName, name)
+	}
+
+	dir, base := filepath.Split(filepath.FromSlash(name))
+
+	shard := []string{s.root, dir}
+	if len(base) >= 4 {
+		shard = append(shard, base[:2], base[2:4])
+	}
+
+	return filepath.Join(append(shard, base)...), nil
+}
+
+func (s *Storage) IsNoSuchPaste(err error) bool {
+	return errors.Is(err, iofs.ErrNotExist)
+}
+
+func (s *Storage) GetFile(_ context.Context, name string) (io.ReadCloser, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get file: %w", err)
+	}
+
+	return f, nil
+}
+
+type sectionReader struct {
+	io.Reader
+	io.Closer
+}
+
+// GetFileRange streams length bytes of the file starting at offset.
+func (s *Storage) GetFileRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	file, err := s.GetFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f := file.(*os.File)
+
+	return sectionReader{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+func (s *Storage) FileSize(_ context.Context, name string) (int64, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat file: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+func (s *Storage) PutFile(_ context.Context, name string, data io.Reader, _ int64, _ string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, tmpDir), "put-")
+	if err != nil {
+		return fmt.Errorf("cannot create file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("cannot put file: %w", err)
+	}
+
+	return s.rename(tmp.Name(), p)
+}
+
+func (s *Storage) MoveFile(_ context.Context, src, dst string) error {
+	srcPath, err := s.path(src)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := s.path(dst)
+	if err != nil {
+		return err
+	}
+
+	return s.rename(srcPath, dstPath)
+}
+
+func (s *Storage) rename(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return fmt.Errorf("cannot create directory: %w", err)
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("cannot move file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeleteFile(_ context.Context, name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return fmt.Errorf("cannot delete file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsPasteExist(_ context.Context, name string) bool {
+	p, err := s.path(name)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(p)
+
+	return err == nil
+}
+
+// Ping checks that the root directory is still there.
+func (s *Storage) Ping(_ context.Context) error {
+	_, err := os.Stat(filepath.Join(s.root, tmpDir))
+	return err
+}
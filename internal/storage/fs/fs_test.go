@@ -0,0 +1,219 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "abcdef", want: "ab/cd/abcdef"},
+		{name: "uploads/abcdef", want: "uploads/ab/cd/abcdef"},
+		{name: "abc", want: "abc"},
+		{name: "../etc/passwd", wantErr: true},
+		{name: "a/../../b", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: "", wantErr: true},
+	}
+
+	s := &Storage{root: "/root"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.path(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error is %v, want error %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				if !errors.Is(err, errBadName) {
+					t.Errorf("error is %v, want %v", err, errBadName)
+				}
+
+				return
+			}
+
+			if want := filepath.Join("/root", filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("path is %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// Every call refuses names outside the root.
+func TestBadName(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	for _, name := range []string{"../escape", "/etc/passwd"} {
+		calls := map[string]error{
+			"PutFile":    s.PutFile(ctx, name, strings.NewReader("lorem"), 5, ""),
+			"MoveFile":   s.MoveFile(ctx, "abcdef", name),
+			"DeleteFile": s.DeleteFile(ctx, name),
+		}
+
+		_, calls["GetFile"] = s.GetFile(ctx, name)
+		_, calls["FileSize"] = s.FileSize(ctx, name)
+
+		for call, err := range calls {
+			if !errors.Is(err, errBadName) {
+				t.Errorf("%s(%q) is %v, want %v", call, name, err, errBadName)
+			}
+		}
+
+		if s.IsPasteExist(ctx, name) {
+			t.Errorf("%q exists", name)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(s.root), "escape")); err == nil {
+		t.Error("file written outside the root")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	if err := s.PutFile(ctx, "abcdef", strings.NewReader("lorem ipsum"), -1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.root, "ab", "cd", "abcdef")); err != nil {
+		t.Errorf("file is not sharded: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "whole", length: -1, want: "lorem ipsum"},
+		{name: "range", offset: 6, length: 3, want: "ips"},
+		{name: "range past the end", offset: 6, length: 100, want: "ipsum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f io.ReadCloser
+			var err error
+
+			if tt.length < 0 {
+				f, err = s.GetFile(ctx, "abcdef")
+			} else {
+				f, err = s.GetFileRange(ctx, "abcdef", tt.offset, tt.length)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("content is %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if size, err := s.FileSize(ctx, "abcdef"); err != nil || size != 11 {
+		t.Errorf("size is %d, %v, want 11", size, err)
+	}
+
+	if err := s.MoveFile(ctx, "abcdef", "uploads/ghijkl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.IsPasteExist(ctx, "abcdef") || !s.IsPasteExist(ctx, "uploads/ghijkl") {
+		t.Error("file not moved")
+	}
+
+	if err := s.DeleteFile(ctx, "uploads/ghijkl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.IsPasteExist(ctx, "uploads/ghijkl") {
+		t.Error("file not deleted")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	_, getErr := s.GetFile(ctx, "missing")
+	_, rangeErr := s.GetFileRange(ctx, "missing", 0, 1)
+	_, sizeErr := s.FileSize(ctx, "missing")
+
+	for call, err := range map[string]error{
+		"GetFile":      getErr,
+		"GetFileRange": rangeErr,
+		"FileSize":     sizeErr,
+		"MoveFile":     s.MoveFile(ctx, "missing", "other"),
+	} {
+		if !s.IsNoSuchPaste(err) {
+			t.Errorf("%s of a missing file is %v, want no such paste", call, err)
+		}
+	}
+
+	if err := s.DeleteFile(ctx, "missing"); err != nil {
+		t.Errorf("deleting a missing file is %v, want nil", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+// A failed write leaves neither the file nor its temporary file behind.
+func TestPutFileAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	if err := s.PutFile(ctx, "abcdef", strings.NewReader("lorem"), 5, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data := io.MultiReader(strings.NewReader("ipsum"), failingReader{})
+	if err := s.PutFile(ctx, "abcdef", data, 5, ""); err == nil {
+		t.Fatal("put of a failing reader succeeded")
+	}
+
+	f, err := s.GetFile(ctx, "abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got, _ := io.ReadAll(f); string(got) != "lorem" {
+		t.Errorf("content is %q, want the previous one", got)
+	}
+
+	if left, _ := os.ReadDir(filepath.Join(s.root, tmpDir)); len(left) != 0 {
+		t.Errorf("temporary files left: %v", left)
+	}
+}
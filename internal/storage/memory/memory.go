@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var errNotFound = errors.New("file not found")
+
+// Storage keeps files in memory, it is meant for tests and local runs.
+type Storage struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func New() *Storage {
+	return &Storage{
+		files: make(map[string][]byte),
+	}
+}
+
+func (s *Storage) IsNoSuchPaste(err error) bool {
+	return errors.Is(err, errNotFound)
+}
+
+func (s *Storage) GetFile(_ context.Context, name string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot get file %s: %w", name, errNotFound)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// GetFileRange returns length bytes of the file starting at offset.
+func (s *Storage) GetFileRange(_ context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot get file %s: %w", name, errNotFound)
+	}
+
+	return io.NopCloser(io.NewSectionReader(bytes.NewReader(data), offset, length)), nil
+}
+
+func (s *Storage) FileSize(_ context.Context, name string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.files[name]
+	if !ok {
+		return 0, fmt.Errorf("cannot stat file %s: %w", name, errNotFound)
+	}
+
+	return int64(len(data)), nil
+}
+
+func (s *Storage) PutFile(_ context.Context, name string, data io.Reader, _ int64, _ string) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("cannot put file: %w", err)
+	}
+
+	s.mu.Lock()
+	s.files[name] = b
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Storage) MoveFile(_ context.Context, src, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[src]
+	if !ok {
+		return fmt.Errorf("cannot move file %s: %w", src, errNotFound)
+	}
+
+	s.files[dst] = data
+	delete(s.files, src)
+
+	return nil
+}
+
+func (s *Storage) DeleteFile(_ context.Context, name string) error {
+	s.mu.Lock()
+	delete(s.files, name)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Storage) IsPasteExist(_ context.Context, name string) bool {
+	s.mu.RLock()
+	_, ok := s.files[name]
+	s.mu.RUnlock()
+
+	return ok
+}
+
+func (s *Storage) Ping(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	if err := s.PutFile(ctx, "abcdef", strings.NewReader("lorem ipsum"), -1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "whole", length: -1, want: "lorem ipsum"},
+		{name: "range", offset: 6, length: 3, want: "ips"},
+		{name: "range past the end", offset: 6, length: 100, want: "ipsum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f io.ReadCloser
+			var err error
+
+			if tt.length < 0 {
+				f, err = s.GetFile(ctx, "abcdef")
+			} else {
+				f, err = s.GetFileRange(ctx, "abcdef", tt.offset, tt.length)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("content is %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if size, err := s.FileSize(ctx, "abcdef"); err != nil || size != 11 {
+		t.Errorf("size is %d, %v, want 11", size, err)
+	}
+
+	if err := s.MoveFile(ctx, "abcdef", "uploads/ghijkl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.IsPasteExist(ctx, "abcdef") || !s.IsPasteExist(ctx, "uploads/ghijkl") {
+		t.Error("file not moved")
+	}
+
+	if err := s.DeleteFile(ctx, "uploads/ghijkl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.IsPasteExist(ctx, "uploads/ghijkl") {
+		t.Error("file not deleted")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	_, getErr := s.GetFile(ctx, "missing")
+	_, rangeErr := s.GetFileRange(ctx, "missing", 0, 1)
+	_, sizeErr := s.FileSize(ctx, "missing")
+
+	for call, err := range map[string]error{
+		"GetFile":      getErr,
+		"GetFileRange": rangeErr,
+		"FileSize":     sizeErr,
+		"MoveFile":     s.MoveFile(ctx, "missing", "other"),
+	} {
+		if !s.IsNoSuchPaste(err) {
+			t.Errorf("%s of a missing file is %v, want no such paste", call, err)
+		}
+	}
+
+	if err := s.DeleteFile(ctx, "missing"); err != nil {
+		t.Errorf("deleting a missing file is %v, want nil", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+// A failed write keeps the previous content.
+func TestPutFileAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	if err := s.PutFile(ctx, "abcdef", strings.NewReader("lorem"), 5, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data := io.MultiReader(strings.NewReader("ipsum"), failingReader{})
+	if err := s.PutFile(ctx, "abcdef", data, 5, ""); err == nil {
+		t.Fatal("put of a failing reader succeeded")
+	}
+
+	f, err := s.GetFile(ctx, "abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got, _ := io.ReadAll(f); string(got) != "lorem" {
+		t.Errorf("content is %q, want the previous one", got)
+	}
+}
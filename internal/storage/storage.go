@@ -17,6 +17,8 @@ type Storage struct {
 }
 
 type Config struct {
+	Driver          string
+	Path            string
 	Addr            string
 	AccessKeyID     string
 	SecretAccessKey string
@@ -70,9 +72,45 @@ func (s *Storage) GetFile(ctx context.Context, name string) (io.ReadCloser, erro
 		return nil, fmt.Errorf("cannot get file: %w", err)
 	}
 
+	// GetObject is lazy, Stat sends the request so missing objects are
+	// reported here rather than on the first Read.
+	if _, err = obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// GetFileRange streams length bytes of the file starting at offset.
+func (s *Storage) GetFileRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("cannot set range: %w", err)
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, name, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get file: %w", err)
+	}
+
+	if _, err = obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+
 	return obj, nil
 }
 
+func (s *Storage) FileSize(ctx context.Context, name string) (int64, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat file: %w", err)
+	}
+
+	return info.Size, nil
+}
+
 func (s *Storage) DeleteFile(ctx context.Context, name string) error {
 	err := s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
 	if err != nil {
@@ -82,10 +120,52 @@ func (s *Storage) DeleteFile(ctx context.Context, name string) error {
 	return nil
 }
 
-func (s *Storage) PutFile(ctx context.Context, name string, data io.Reader, size int64) error {
-	_, err := s.client.PutObject(ctx, s.bucket, name, data, size, minio.PutObjectOptions{
-		ContentType: "text/plain",
-	})
+func (s *Storage) MoveFile(ctx context.Context, src, dst string) error {
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src},
+	)
+	if err != nil {
+		return fmt.Errorf("cannot copy file: %w", err)
+	}
+
+	return s.DeleteFile(ctx, src)
+}
+
+// streamPartSize is the part size of uploads of unknown size. minio-go
+// buffers a whole part, left to itself it picks parts fit for the largest
+// object S3 allows, hundreds of MiB each.
+const streamPartSize = 16 << 20
+
+// codecKey is the object metadata entry naming the encoding of the object,
+// it is absent on raw objects. The blob name records the codec as well,
+// the entry tells it to tools that only look at the bucket.
+const codecKey = "Codec"
+
+// putOptions returns the options to upload an object of size bytes, -1 if
+// it is not known in advance, encoded with codec, "" if raw. Equal contents
+// of different pastes share one object, so the object is untyped and the
+// type of each paste is kept in the repository.
+func putOptions(size int64, codec string) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	}
+
+	if codec != "" {
+		opts.UserMetadata = map[string]string{codecKey: codec}
+	}
+
+	if size < 0 {
+		opts.PartSize = streamPartSize
+	}
+
+	return opts
+}
+
+// PutFile uploads data, size may be -1 if it is not known in advance. The
+// codec is kept in the object metadata.
+func (s *Storage) PutFile(ctx context.Context, name string, data io.Reader, size int64, codec string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, name, data, size, putOptions(size, codec))
 	if err != nil {
 		return fmt.Errorf("cannot put file: %w", err)
 	}
@@ -97,3 +177,16 @@ func (s *Storage) IsPasteExist(ctx context.Context, name string) bool {
 	_, err := s.client.StatObject(ctx, s.bucket, name, minio.GetObjectOptions{})
 	return err == nil
 }
+
+func (s *Storage) Ping(ctx context.Context) error {
+	ok, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("bucket %s does not exist", s.bucket)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import "testing"
+
+func TestPutOptionsPartSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want uint64
+	}{
+		{name: "known size", size: 42},
+		{name: "empty", size: 0},
+		{name: "unknown size", size: -1, want: streamPartSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := putOptions(tt.size, "").PartSize; got != tt.want {
+				t.Errorf("part size is %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutOptionsCodec(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec string
+		want  string
+		ok    bool
+	}{
+		{name: "raw", codec: ""},
+		{name: "zstd", codec: "zstd", want: "zstd", ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := putOptions(-1, tt.codec).UserMetadata[codecKey]
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("codec metadata is %q (set %v), want %q (set %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package tracing sets up OpenTelemetry tracing. Backends are traced by
+// wrapping them before they are handed to the service, the same way
+// package metrics instruments them.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const serviceName = "gopetbin"
+
+type Config struct {
+	Exporter string
+	Endpoint string /* OTLP/HTTP collector host:port */
+	Insecure bool
+	Path     string /* file of stdout exporter, empty writes to stdout */
+}
+
+// Setup installs the global tracer provider and returns a function flushing
+// and stopping it. With no exporter configured the global no-op provider is
+// left in place.
+func Setup(ctx context.Context, c Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+
+	switch c.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		var w io.Writer = os.Stdout
+
+		if c.Path != "" {
+			f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open trace file: %w", err)
+			}
+
+			w, closer = f, f
+		}
+
+		e, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create stdout exporter: %w", err)
+		}
+
+		exporter = e
+
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		e, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create otlp exporter: %w", err)
+		}
+
+		exporter = e
+
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", c.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+
+		return err
+	}, nil
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+var tracer = otel.Tracer("github.com/swmh/gopetbin/internal/tracing")
+
+func start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindClient))
+}
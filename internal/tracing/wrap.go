@@ -0,0 +1,300 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/swmh/gopetbin/internal/service"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	keyID   = attribute.Key("paste.id")
+	keyName = attribute.Key("paste.name")
+	keyHit  = attribute.Key("cache.hit")
+)
+
+// end is End for lookups, a missing paste is an expected outcome and does
+// not mark the span as failed.
+func end(span trace.Span, err error, c service.NoSuchPasteChecker) {
+	if err != nil && c.IsNoSuchPaste(err) {
+		span.SetAttributes(attribute.Bool("paste.not_found", true))
+		err = nil
+	}
+
+	End(span, err)
+}
+
+type storage struct {
+	service.Storage
+}
+
+// Storage wraps s to trace its calls.
+func Storage(s service.Storage) service.Storage {
+	return &storage{s}
+}
+
+func (s *storage) PutFile(ctx context.Context, name string, data io.Reader, size int64, codec string) (err error) {
+	ctx, span := start(ctx, "storage.PutFile", keyName.String(name), attribute.Int64("paste.size", size))
+	defer func() { End(span, err) }()
+
+	return s.Storage.PutFile(ctx, name, data, size, codec)
+}
+
+func (s *storage) GetFile(ctx context.Context, name string) (_ io.ReadCloser, err error) {
+	ctx, span := start(ctx, "storage.GetFile", keyName.String(name))
+	defer func() { end(span, err, s.Storage) }()
+
+	return s.Storage.GetFile(ctx, name)
+}
+
+func (s *storage) GetFileRange(ctx context.Context, name string, offset, length int64) (_ io.ReadCloser, err error) {
+	ctx, span := start(ctx, "storage.GetFileRange", keyName.String(name),
+		attribute.Int64("range.offset", offset), attribute.Int64("range.length", length))
+	defer func() { end(span, err, s.Storage) }()
+
+	return s.Storage.GetFileRange(ctx, name, offset, length)
+}
+
+func (s *storage) FileSize(ctx context.Context, name string) (_ int64, err error) {
+	ctx, span := start(ctx, "storage.FileSize", keyName.String(name))
+	defer func() { end(span, err, s.Storage) }()
+
+	return s.Storage.FileSize(ctx, name)
+}
+
+func (s *storage) MoveFile(ctx context.Context, src, dst string) (err error) {
+	ctx, span := start(ctx, "storage.MoveFile", attribute.String("src", src), attribute.String("dst", dst))
+	defer func() { End(span, err) }()
+
+	return s.Storage.MoveFile(ctx, src, dst)
+}
+
+func (s *storage) DeleteFile(ctx context.Context, name string) (err error) {
+	ctx, span := start(ctx, "storage.DeleteFile", keyName.String(name))
+	defer func() { end(span, err, s.Storage) }()
+
+	return s.Storage.DeleteFile(ctx, name)
+}
+
+func (s *storage) IsPasteExist(ctx context.Context, name string) bool {
+	ctx, span := start(ctx, "storage.IsPasteExist", keyName.String(name))
+	defer span.End()
+
+	return s.Storage.IsPasteExist(ctx, name)
+}
+
+type repo struct {
+	service.Repository
+}
+
+// Repository wraps r to trace its calls.
+func Repository(r service.Repository) service.Repository {
+	return &repo{r}
+}
+
+func (r *repo) CreatePaste(ctx context.Context, id string, paste service.Paste, place service.BlobFunc) (err error) {
+	ctx, span := start(ctx, "db.CreatePaste", keyID.String(id))
+	defer func() { End(span, err) }()
+
+	return r.Repository.CreatePaste(ctx, id, paste, place)
+}
+
+func (r *repo) GetPaste(ctx context.Context, id string) (_ service.Paste, err error) {
+	ctx, span := start(ctx, "db.GetPaste", keyID.String(id))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.GetPaste(ctx, id)
+}
+
+func (r *repo) Consume(ctx context.Context, id string) (_ int, err error) {
+	ctx, span := start(ctx, "db.Consume", keyID.String(id))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.Consume(ctx, id)
+}
+
+func (r *repo) UpdatePaste(ctx context.Context, id string, name string, checksum string, size int64, place service.BlobFunc) (_ int, err error) {
+	ctx, span := start(ctx, "db.UpdatePaste", keyID.String(id))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.UpdatePaste(ctx, id, name, checksum, size, place)
+}
+
+func (r *repo) GetRevision(ctx context.Context, id string, revision int) (_ string, _ string, err error) {
+	ctx, span := start(ctx, "db.GetRevision", keyID.String(id), attribute.Int("paste.revision", revision))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.GetRevision(ctx, id, revision)
+}
+
+func (r *repo) DeletePaste(ctx context.Context, id string) (_ []string, err error) {
+	ctx, span := start(ctx, "db.DeletePaste", keyID.String(id))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.DeletePaste(ctx, id)
+}
+
+func (r *repo) GetUserByKey(ctx context.Context, keyHash string) (_ service.User, err error) {
+	ctx, span := start(ctx, "db.GetUserByKey")
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.GetUserByKey(ctx, keyHash)
+}
+
+func (r *repo) ListPastes(ctx context.Context, owner int64, limit, offset int) (_ []service.ListedPaste, err error) {
+	ctx, span := start(ctx, "db.ListPastes", attribute.Int64("user.id", owner))
+	defer func() { End(span, err) }()
+
+	return r.Repository.ListPastes(ctx, owner, limit, offset)
+}
+
+func (r *repo) DeleteBlob(ctx context.Context, name string, remove service.BlobFunc) (err error) {
+	ctx, span := start(ctx, "db.DeleteBlob", keyName.String(name))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.DeleteBlob(ctx, name, remove)
+}
+
+func (r *repo) BlobStats(ctx context.Context) (_ service.BlobStats, err error) {
+	ctx, span := start(ctx, "db.BlobStats")
+	defer func() { End(span, err) }()
+
+	return r.Repository.BlobStats(ctx)
+}
+
+func (r *repo) FindPastes(ctx context.Context, f service.PasteFilter) (_ []service.ListedPaste, err error) {
+	ctx, span := start(ctx, "db.FindPastes")
+	defer func() { End(span, err) }()
+
+	return r.Repository.FindPastes(ctx, f)
+}
+
+func (r *repo) BlockHash(ctx context.Context, hash string, reason string) (err error) {
+	ctx, span := start(ctx, "db.BlockHash", keyName.String(hash))
+	defer func() { End(span, err) }()
+
+	return r.Repository.BlockHash(ctx, hash, reason)
+}
+
+func (r *repo) UnblockHash(ctx context.Context, hash string) (err error) {
+	ctx, span := start(ctx, "db.UnblockHash", keyName.String(hash))
+	defer func() { end(span, err, r.Repository) }()
+
+	return r.Repository.UnblockHash(ctx, hash)
+}
+
+func (r *repo) IsBlocked(ctx context.Context, hash string) (_ bool, err error) {
+	ctx, span := start(ctx, "db.IsBlocked", keyName.String(hash))
+	defer func() { End(span, err) }()
+
+	return r.Repository.IsBlocked(ctx, hash)
+}
+
+func (r *repo) LegacyBlobs(ctx context.Context, after string, limit int) (_ []string, err error) {
+	ctx, span := start(ctx, "db.LegacyBlobs")
+	defer func() { End(span, err) }()
+
+	return r.Repository.LegacyBlobs(ctx, after, limit)
+}
+
+func (r *repo) RenameBlob(ctx context.Context, old, name, checksum string, place service.BlobFunc) (_ []string, err error) {
+	ctx, span := start(ctx, "db.RenameBlob", keyName.String(name))
+	defer func() { End(span, err) }()
+
+	return r.Repository.RenameBlob(ctx, old, name, checksum, place)
+}
+
+type cache struct {
+	service.Cache
+}
+
+// Cache wraps c to trace its calls.
+func Cache(c service.Cache) service.Cache {
+	return &cache{c}
+}
+
+func (c *cache) Get(ctx context.Context, key string) (_ string, err error) {
+	ctx, span := start(ctx, "cache.Get", keyID.String(key))
+	defer func() {
+		span.SetAttributes(keyHit.Bool(err == nil))
+		end(span, err, c.Cache)
+	}()
+
+	return c.Cache.Get(ctx, key)
+}
+
+func (c *cache) Set(ctx context.Context, key string, value service.Paste) (err error) {
+	ctx, span := start(ctx, "cache.Set", keyID.String(key))
+	defer func() { End(span, err) }()
+
+	return c.Cache.Set(ctx, key, value)
+}
+
+func (c *cache) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := start(ctx, "cache.Delete", keyID.String(key))
+	defer func() { End(span, err) }()
+
+	return c.Cache.Delete(ctx, key)
+}
+
+type fileCache struct {
+	service.FileCache
+}
+
+// FileCache wraps c to trace its calls.
+func FileCache(c service.FileCache) service.FileCache {
+	return &fileCache{c}
+}
+
+func (c *fileCache) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	ctx, span := start(ctx, "file_cache.Get", keyID.String(key))
+	defer func() {
+		span.SetAttributes(keyHit.Bool(err == nil))
+		end(span, err, c.FileCache)
+	}()
+
+	return c.FileCache.Get(ctx, key)
+}
+
+func (c *fileCache) GetRange(ctx context.Context, key string, offset, length int64) (_ io.ReadCloser, err error) {
+	ctx, span := start(ctx, "file_cache.GetRange", keyID.String(key),
+		attribute.Int64("range.offset", offset), attribute.Int64("range.length", length))
+	defer func() {
+		span.SetAttributes(keyHit.Bool(err == nil))
+		end(span, err, c.FileCache)
+	}()
+
+	return c.FileCache.GetRange(ctx, key, offset, length)
+}
+
+func (c *fileCache) Set(ctx context.Context, key string, value []byte) (err error) {
+	ctx, span := start(ctx, "file_cache.Set", keyID.String(key), attribute.Int("paste.size", len(value)))
+	defer func() { End(span, err) }()
+
+	return c.FileCache.Set(ctx, key, value)
+}
+
+func (c *fileCache) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := start(ctx, "file_cache.Delete", keyID.String(key))
+	defer func() { End(span, err) }()
+
+	return c.FileCache.Delete(ctx, key)
+}
+
+type locker struct {
+	service.Locker
+}
+
+// Locker wraps l to trace lock acquisition, including any retry backoff.
+func Locker(l service.Locker) service.Locker {
+	return &locker{l}
+}
+
+func (l *locker) Lock(ctx context.Context, id string) (_ service.Mutex, err error) {
+	ctx, span := start(ctx, "locker.Lock", keyID.String(id))
+	defer func() { End(span, err) }()
+
+	return l.Locker.Lock(ctx, id)
+}
@@ -0,0 +1,170 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/swmh/gopetbin/internal/cache"
+	"github.com/swmh/gopetbin/internal/service"
+	"github.com/swmh/gopetbin/internal/service/servicetest"
+	"github.com/swmh/gopetbin/internal/storage/memory"
+	"github.com/swmh/gopetbin/internal/tracing"
+)
+
+// exporter gets every span ended in the tests. The tracer of the package
+// binds to the first global provider, so it is installed once.
+var exporter = tracetest.NewInMemoryExporter()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	os.Exit(m.Run())
+}
+
+var errDown = errors.New("backend down")
+
+// broken fails every call it implements.
+type broken struct {
+	service.Repository
+}
+
+func (broken) GetPaste(context.Context, string) (service.Paste, error) {
+	return service.Paste{}, errDown
+}
+
+func (broken) IsNoSuchPaste(error) bool { return false }
+
+func TestSpans(t *testing.T) {
+	ctx := context.Background()
+
+	strg := tracing.Storage(memory.New())
+	repo := tracing.Repository(servicetest.NewRepo(t))
+	cach := tracing.Cache(cache.NewMemory(1 << 20))
+
+	tests := []struct {
+		name     string
+		call     func() error
+		span     string
+		failed   bool
+		notFound bool
+		attrs    []attribute.KeyValue
+	}{
+		{
+			name: "put",
+			call: func() error { return strg.PutFile(ctx, "a", strings.NewReader("lorem"), 5, "") },
+			span: "storage.PutFile",
+			attrs: []attribute.KeyValue{
+				attribute.String("paste.name", "a"),
+				attribute.Int64("paste.size", 5),
+			},
+		},
+		{
+			name:     "get missing file",
+			call:     func() error { _, err := strg.GetFile(ctx, "missing"); return err },
+			span:     "storage.GetFile",
+			notFound: true,
+		},
+		{
+			name:     "get missing paste",
+			call:     func() error { _, err := repo.GetPaste(ctx, "missing"); return err },
+			span:     "db.GetPaste",
+			notFound: true,
+			attrs:    []attribute.KeyValue{attribute.String("paste.id", "missing")},
+		},
+		{
+			name:   "repo down",
+			call:   func() error { _, err := tracing.Repository(broken{}).GetPaste(ctx, "4Gp3gCWeXl"); return err },
+			span:   "db.GetPaste",
+			failed: true,
+		},
+		{
+			name:     "cache miss",
+			call:     func() error { _, err := cach.Get(ctx, "missing"); return err },
+			span:     "cache.Get",
+			notFound: true,
+			attrs:    []attribute.KeyValue{attribute.Bool("cache.hit", false)},
+		},
+		{
+			name: "cache set",
+			call: func() error { return cach.Set(ctx, "4Gp3gCWeXl", service.Paste{Name: "a"}) },
+			span: "cache.Set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+
+			if err := tt.call(); (err != nil) != (tt.failed || tt.notFound) {
+				t.Fatalf("call failed with %v", err)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+
+			s := spans[0]
+			if s.Name != tt.span {
+				t.Errorf("span is %s, want %s", s.Name, tt.span)
+			}
+
+			if got := s.Status.Code == codes.Error; got != tt.failed {
+				t.Errorf("span failed is %v, want %v", got, tt.failed)
+			}
+
+			if got := len(s.Events) == 1 && s.Events[0].Name == "exception"; got != tt.failed {
+				t.Errorf("error recorded is %v, want %v", got, tt.failed)
+			}
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, a := range s.Attributes {
+				attrs[a.Key] = a.Value
+			}
+
+			if got := attrs["paste.not_found"].AsBool(); got != tt.notFound && !tt.failed {
+				t.Errorf("not found is %v, want %v", got, tt.notFound)
+			}
+
+			for _, a := range tt.attrs {
+				if got, ok := attrs[a.Key]; !ok || got != a.Value {
+					t.Errorf("attribute %s is %v, want %v", a.Key, got.Emit(), a.Value.Emit())
+				}
+			}
+		})
+	}
+}
+
+// Spans still buffered by the batcher are written on shutdown.
+func TestSetupFlush(t *testing.T) {
+	path := t.TempDir() + "/traces.json"
+
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterStdout, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "flushed")
+	span.End()
+
+	if err = shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"Name":"flushed"`) {
+		t.Errorf("span not flushed, traces are %q", data)
+	}
+}
@@ -0,0 +1,260 @@
+// Package client is a gopetbin client. Pastes created with CreateEncrypted
+// are encrypted with AES-GCM before they leave the client, the key is only
+// kept in the fragment of the returned URL which is never sent to the server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const keySize = 32
+
+var (
+	ErrBadKey    = errors.New("bad key")
+	ErrBadStatus = errors.New("bad status")
+)
+
+type Options struct {
+	Expire    time.Duration
+	BurnAfter int
+	Lang      string
+	Password  string
+
+	ContentType string /* sniffed by the server if empty */
+	Filename    string
+}
+
+type Client struct {
+	addr   string
+	client *http.Client
+}
+
+func New(addr string, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Client{
+		addr:   strings.TrimSuffix(addr, "/"),
+		client: client,
+	}
+}
+
+// Create uploads content and returns the paste URL.
+func (c *Client) Create(ctx context.Context, content io.Reader, opts Options) (string, error) {
+	return c.create(ctx, content, opts, false)
+}
+
+// CreateEncrypted encrypts content with a new random key, uploads the
+// ciphertext and returns the paste URL with the key in its fragment.
+func (c *Client) CreateEncrypted(ctx context.Context, content []byte, opts Options) (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("cannot generate key: %w", err)
+	}
+
+	ciphertext, err := Encrypt(key, content)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := c.create(ctx, strings.NewReader(ciphertext), opts, true)
+	if err != nil {
+		return "", err
+	}
+
+	return u + "#" + base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// Get downloads the paste at pasteURL, if the URL has a key in its
+// fragment the content is decrypted with it.
+func (c *Client) Get(ctx context.Context, pasteURL string, password string) ([]byte, error) {
+	u, err := url.Parse(pasteURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse url: %w", err)
+	}
+
+	fragment := u.Fragment
+	u.Fragment = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if password != "" {
+		req.Header.Set("X-Paste-Password", password)
+	}
+
+	data, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if fragment == "" {
+		return data, nil
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(fragment)
+	if err != nil {
+		return nil, errors.Join(err, ErrBadKey)
+	}
+
+	return Decrypt(key, string(data))
+}
+
+func (c *Client) create(ctx context.Context, content io.Reader, opts Options, encrypted bool) (string, error) {
+	var body bytes.Buffer
+
+	w := multipart.NewWriter(&body)
+
+	fields := map[string]string{}
+	if opts.Expire > 0 {
+		fields["expire"] = opts.Expire.String()
+	}
+
+	if opts.BurnAfter > 0 {
+		fields["burn"] = strconv.Itoa(opts.BurnAfter)
+	}
+
+	if opts.Lang != "" {
+		fields["lang"] = opts.Lang
+	}
+
+	if opts.Password != "" {
+		fields["password"] = opts.Password
+	}
+
+	if encrypted {
+		fields["encrypted"] = "true"
+	}
+
+	if opts.ContentType != "" {
+		fields["content_type"] = opts.ContentType
+	}
+
+	if opts.Filename != "" {
+		fields["filename"] = opts.Filename
+	}
+
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return "", err
+		}
+	}
+
+	part, err := w.CreateFormFile("content", "content")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = io.Copy(part, content); err != nil {
+		return "", err
+	}
+
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+"/", &body)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	data, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+
+	return data, nil
+}
+
+// Encrypt seals plaintext with AES-GCM and returns the base64 encoded
+// nonce followed by the ciphertext.
+func Encrypt(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Decrypt reverses Encrypt.
+func Decrypt(key []byte, ciphertext string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode ciphertext: %w", err)
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, errors.Join(err, ErrBadKey)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, ErrBadKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	plaintext := []byte("lorem ipsum")
+
+	ciphertext, err := Encrypt(newKey(1), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(ciphertext)
+
+	tampered := bytes.Clone(raw)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext string
+		want       []byte
+		badKey     bool
+	}{
+		{name: "round trip", key: newKey(1), ciphertext: ciphertext, want: plaintext},
+		{name: "trailing newline", key: newKey(1), ciphertext: ciphertext + "\n", want: plaintext},
+		{name: "wrong key", key: newKey(2), ciphertext: ciphertext, badKey: true},
+		{name: "short key", key: newKey(1)[:16], ciphertext: ciphertext, badKey: true},
+		{name: "tampered", key: newKey(1), ciphertext: base64.StdEncoding.EncodeToString(tampered), badKey: true},
+		{name: "truncated", key: newKey(1), ciphertext: base64.StdEncoding.EncodeToString(raw[:len(raw)-4]), badKey: true},
+		{name: "shorter than nonce", key: newKey(1), ciphertext: base64.StdEncoding.EncodeToString(raw[:8])},
+		{name: "not base64", key: newKey(1), ciphertext: "%%%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.key, tt.ciphertext)
+			if tt.want != nil {
+				if err != nil || !bytes.Equal(got, tt.want) {
+					t.Errorf("decrypted %q, %v, want %q", got, err, tt.want)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("decrypted %q, want an error", got)
+			}
+
+			if errors.Is(err, ErrBadKey) != tt.badKey {
+				t.Errorf("error is %v, want bad key %v", err, tt.badKey)
+			}
+		})
+	}
+}
+
+// Every encryption gets a nonce of its own.
+func TestEncryptNonce(t *testing.T) {
+	a, err := Encrypt(newKey(1), []byte("lorem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Encrypt(newKey(1), []byte("lorem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b {
+		t.Error("equal plaintexts encrypted to equal ciphertexts")
+	}
+}
+
+// pasteServer stores one paste and serves it at /4Gp3gCWeXl.
+type pasteServer struct {
+	content   []byte
+	encrypted string
+	requested string
+}
+
+func (p *pasteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.requested = r.URL.String()
+
+	if r.Method == http.MethodGet {
+		w.Write(p.content)
+		return
+	}
+
+	file, _, err := r.FormFile("content")
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	p.content, _ = io.ReadAll(file)
+	p.encrypted = r.FormValue("encrypted")
+
+	io.WriteString(w, "http://"+r.Host+"/4Gp3gCWeXl\n")
+}
+
+func TestCreateEncrypted(t *testing.T) {
+	ctx := context.Background()
+
+	p := &pasteServer{}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	c := New(srv.URL, srv.Client())
+
+	u, err := c.CreateEncrypted(ctx, []byte("lorem ipsum"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.encrypted != "true" || bytes.Contains(p.content, []byte("lorem")) {
+		t.Fatalf("server got %q encrypted %q, want ciphertext", p.content, p.encrypted)
+	}
+
+	base, fragment, ok := strings.Cut(u, "#")
+	if !ok || base != srv.URL+"/4Gp3gCWeXl" {
+		t.Fatalf("url is %s, want the paste url with a key", u)
+	}
+
+	if key, err := base64.RawURLEncoding.DecodeString(fragment); err != nil || len(key) != keySize {
+		t.Errorf("fragment %q is not a key", fragment)
+	}
+
+	got, err := c.Get(ctx, u, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "lorem ipsum" {
+		t.Errorf("got %q, want lorem ipsum", got)
+	}
+
+	// The key never reaches the server.
+	if strings.Contains(p.requested, fragment) {
+		t.Errorf("server got the key in %s", p.requested)
+	}
+}
+
+func TestGetFragment(t *testing.T) {
+	ciphertext, err := Encrypt(newKey(1), []byte("lorem ipsum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		fragment string
+		want     string
+		badKey   bool
+	}{
+		{name: "no fragment", content: "lorem ipsum", want: "lorem ipsum"},
+		{name: "no key", content: ciphertext, fragment: "#", want: ciphertext},
+		{name: "key", content: ciphertext, fragment: "#" + base64.RawURLEncoding.EncodeToString(newKey(1)), want: "lorem ipsum"},
+		{name: "wrong key", content: ciphertext, fragment: "#" + base64.RawURLEncoding.EncodeToString(newKey(2)), badKey: true},
+		{name: "short key", content: ciphertext, fragment: "#" + base64.RawURLEncoding.EncodeToString(newKey(1)[:8]), badKey: true},
+		{name: "malformed key", content: ciphertext, fragment: "#not*base64", badKey: true},
+		{name: "padded key", content: ciphertext, fragment: "#" + base64.URLEncoding.EncodeToString(newKey(1)), badKey: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(&pasteServer{content: []byte(tt.content)})
+			defer srv.Close()
+
+			got, err := New(srv.URL, srv.Client()).Get(context.Background(), srv.URL+"/4Gp3gCWeXl"+tt.fragment, "")
+			if tt.badKey {
+				if !errors.Is(err, ErrBadKey) {
+					t.Errorf("error is %v, want %v", err, ErrBadKey)
+				}
+
+				return
+			}
+
+			if err != nil || string(got) != tt.want {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 var DefaultEnvTypes = map[string]any{
 	"int": 0, "int16": 0, "int32": 0, "int64": 0,
 	"string": "string",
+	"bool":   false,
 }
 
 type EnvMarshaler struct{}
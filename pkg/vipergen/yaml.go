@@ -10,6 +10,7 @@ import (
 var DefaultYamlTypes = map[string]any{
 	"int": 0, "int16": 0, "int32": 0, "int64": 0,
 	"string": "",
+	"bool":   false,
 }
 
 type YamlMarshaler struct {